@@ -0,0 +1,150 @@
+package influxunifi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// cardinalityWarnTop is how many measurements are listed in a cardinality warning.
+const cardinalityWarnTop = 5
+
+// seriesCount maps measurement names to the series keys in a report.
+type seriesCount map[string]map[string]struct{}
+
+// countSeries returns the series in a report, and the total number of series.
+func countSeries(r *Report) (seriesCount, int) {
+	series := make(seriesCount)
+	total := 0
+
+	for _, bp := range r.bp {
+		for _, p := range bp.Points() {
+			if series[p.Name()] == nil {
+				series[p.Name()] = make(map[string]struct{})
+			}
+
+			key := seriesKey(&metric{Table: p.Name(), Tags: p.Tags()})
+			if _, ok := series[p.Name()][key]; !ok {
+				series[p.Name()][key] = struct{}{}
+				total++
+			}
+		}
+	}
+
+	return series, total
+}
+
+// warnCardinality logs the largest measurements when a report has more than
+// cardinality_warn series. It never drops anything.
+func (u *InfluxUnifi) warnCardinality(series seriesCount, total int) {
+	if u.CardinalityWarn <= 0 || total <= u.CardinalityWarn {
+		return
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return len(series[names[i]]) > len(series[names[j]]) })
+
+	top := make([]string, 0, cardinalityWarnTop)
+	for i := 0; i < len(names) && i < cardinalityWarnTop; i++ {
+		top = append(top, fmt.Sprintf("%s=%d", names[i], len(series[names[i]])))
+	}
+
+	u.LogErrorf("InfluxDB series count (%d) exceeds cardinality_warn (%d), largest measurements: %s",
+		total, u.CardinalityWarn, strings.Join(top, ", "))
+}
+
+// enforceSeriesBudget drops whole measurements from a report when the number of
+// series in it exceeds series_budget. Measurements are dropped in reverse order
+// of measurement_priority (the first item is the most important). Measurements not
+// in the priority list are dropped first, largest first. The last remaining
+// measurement is never dropped. Report.Series is updated for dropped measurements.
+func (u *InfluxUnifi) enforceSeriesBudget(r *Report, series seriesCount, total int) {
+	if u.SeriesBudget <= 0 {
+		return
+	}
+
+	if total <= u.SeriesBudget {
+		return
+	}
+
+	drop := make(map[string]bool)
+
+	for _, name := range u.dropOrder(series) {
+		if total <= u.SeriesBudget || len(drop) == len(series)-1 {
+			break
+		}
+
+		drop[name] = true
+		total -= len(series[name])
+	}
+
+	points := r.Total
+
+	for db, bp := range r.bp {
+		r.bp[db] = r.filterBatch(bp, func(p *influx.Point) bool { return !drop[p.Name()] })
+	}
+
+	r.dropped("series_budget", points-r.Total)
+
+	r.Series = total
+
+	names := make([]string, 0, len(drop))
+	for name := range drop {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	u.LogErrorf("InfluxDB series budget (%d) exceeded, dropped measurements: %v", u.SeriesBudget, names)
+}
+
+// dropOrder returns measurement names in the order they should be dropped.
+func (u *InfluxUnifi) dropOrder(series seriesCount) []string {
+	unlisted := []string{}
+
+	for name := range series {
+		if !stringInSlice(name, u.MeasurementPriority) {
+			unlisted = append(unlisted, name)
+		}
+	}
+
+	sort.Slice(unlisted, func(i, j int) bool {
+		if len(series[unlisted[i]]) == len(series[unlisted[j]]) {
+			return unlisted[i] < unlisted[j]
+		}
+
+		return len(series[unlisted[i]]) > len(series[unlisted[j]])
+	})
+
+	for i := len(u.MeasurementPriority) - 1; i >= 0; i-- {
+		if _, ok := series[u.MeasurementPriority[i]]; ok {
+			unlisted = append(unlisted, u.MeasurementPriority[i])
+		}
+	}
+
+	return unlisted
+}
+
+// filterBatch returns a copy of a batch with only the points keep() returns true for.
+// Report totals are adjusted for the removed points.
+func (r *Report) filterBatch(bp influx.BatchPoints, keep func(*influx.Point) bool) influx.BatchPoints {
+	out := emptyBatch(bp)
+
+	for _, p := range bp.Points() {
+		if keep(p) {
+			out.AddPoint(p)
+			continue
+		}
+
+		fields, _ := p.Fields()
+		r.Total--
+		r.Fields -= len(fields)
+	}
+
+	return out
+}
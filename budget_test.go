@@ -0,0 +1,116 @@
+package influxunifi
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// budgetReport returns a report with one point per series for each measurement,
+// plus a repeated point in "d", and the series counted in it.
+func budgetReport(t *testing.T) (*Report, seriesCount, int) {
+	t.Helper()
+
+	bp, _ := influx.NewBatchPoints(influx.BatchPointsConfig{Database: "unifi"})
+	r := &Report{bp: map[string]influx.BatchPoints{"unifi": bp}}
+
+	add := func(name string, id int) {
+		p, err := influx.NewPoint(name, map[string]string{"id": strconv.Itoa(id)},
+			map[string]interface{}{"value": 1}, testTime)
+		if err != nil {
+			t.Fatalf("creating point: %v", err)
+		}
+
+		bp.AddPoint(p)
+		r.Total++
+		r.Fields++
+	}
+
+	for name, n := range map[string]int{"a": 3, "b": 2, "c": 1, "d": 4} {
+		for i := 0; i < n; i++ {
+			add(name, i)
+		}
+	}
+
+	add("d", 0)
+
+	series, total := countSeries(r)
+
+	return r, series, total
+}
+
+func TestDropOrder(t *testing.T) {
+	_, series, _ := budgetReport(t)
+
+	tests := []struct {
+		priority []string
+		want     []string
+	}{
+		{priority: nil, want: []string{"d", "a", "b", "c"}},
+		{priority: []string{"a", "b"}, want: []string{"d", "c", "b", "a"}},
+		{priority: []string{"d", "c", "b", "a"}, want: []string{"a", "b", "c", "d"}},
+		{priority: []string{"c", "missing"}, want: []string{"d", "a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		u, _, _ := testUnifi(t, &Config{MeasurementPriority: test.priority})
+
+		if got := u.dropOrder(series); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("priority %v: drop order = %v, want %v", test.priority, got, test.want)
+		}
+	}
+}
+
+func TestEnforceSeriesBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		budget  int
+		dropped []string // measurements removed from the report.
+		series  int
+		points  int // points dropped with series_budget.
+	}{
+		{name: "disabled", budget: 0, series: 10},
+		{name: "at budget", budget: 10, series: 10},
+		{name: "largest unlisted", budget: 6, dropped: []string{"d"}, series: 6, points: 5},
+		{name: "priority reverse", budget: 3, dropped: []string{"b", "c", "d"}, series: 3, points: 8},
+		{name: "last kept", budget: 1, dropped: []string{"b", "c", "d"}, series: 3, points: 8},
+	}
+
+	for _, test := range tests {
+		u, _, l := testUnifi(t, &Config{SeriesBudget: test.budget, MeasurementPriority: []string{"a", "b"}})
+		r, series, total := budgetReport(t)
+		r.Series = total
+		points := r.Total
+
+		u.enforceSeriesBudget(r, series, total)
+
+		if r.Series != test.series {
+			t.Errorf("%s: Series = %d, want %d", test.name, r.Series, test.series)
+		}
+
+		if r.Dropped["series_budget"] != test.points {
+			t.Errorf("%s: dropped series_budget = %d, want %d", test.name, r.Dropped["series_budget"], test.points)
+		}
+
+		if r.Total != points-test.points || r.Fields != points-test.points {
+			t.Errorf("%s: Total, Fields = %d, %d, want %d", test.name, r.Total, r.Fields, points-test.points)
+		}
+
+		left := make(map[string]bool)
+		for _, p := range r.bp["unifi"].Points() {
+			left[p.Name()] = true
+		}
+
+		for _, name := range []string{"a", "b", "c", "d"} {
+			if left[name] == stringInSlice(name, test.dropped) {
+				t.Errorf("%s: measurement %s kept = %v", test.name, name, left[name])
+			}
+		}
+
+		if logged := l.count("series budget") > 0; logged != (test.dropped != nil) {
+			t.Errorf("%s: budget warning logged = %v", test.name, logged)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package influxunifi
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+)
+
+// bufferPoints adds a report's batches to the write buffer. PollController flushes
+// the buffer every flush_interval, and when it stops. The buffer is also flushed
+// here once it holds buffer_size points, so a long flush_interval on a large network
+// does not hold more than that in memory. buffer_size defaults to 20 batch_size
+// chunks; a network that produces more points than that during one flush_interval
+// is flushed early, and should raise buffer_size to keep the flush_interval.
+// The buffer has its own lock, so concurrent ReportMetrics calls are safe.
+func (u *InfluxUnifi) bufferPoints(ctx context.Context, r *Report) error {
+	u.bufferMu.Lock()
+
+	if u.buffer == nil {
+		u.buffer = make(map[string]influx.BatchPoints)
+	}
+
+	buffered := 0
+
+	for key, bp := range r.bp {
+		if u.buffer[key] == nil {
+			u.buffer[key] = emptyBatch(bp)
+		}
+
+		u.buffer[key].AddPoints(bp.Points())
+		buffered += len(u.buffer[key].Points())
+	}
+
+	if buffered < u.BufferSize {
+		u.bufferMu.Unlock()
+		return nil
+	}
+
+	batches := u.buffer
+	u.buffer = nil
+	u.bufferMu.Unlock()
+
+	failed, err := u.writeBatches(ctx, r, batches)
+	u.requeue(failed)
+
+	return err
+}
+
+// flushBuffer writes any buffered points to InfluxDB, and records the outcome for the
+// probe handler. Write retries stop when ctx is done.
+func (u *InfluxUnifi) flushBuffer(ctx context.Context) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if r, err := u.writeBuffer(ctx); err != nil || r.wrote() {
+		u.probed(r, err)
+	}
+}
+
+// writeBuffer writes any buffered points to InfluxDB, and returns a report with the
+// written and failed counts. The caller must hold mu. Batches that failed to write
+// before are retried first. They already went to the mirrors and output_file, so
+// they are only written to the InfluxDB server. Errors are logged and returned.
+func (u *InfluxUnifi) writeBuffer(ctx context.Context) (*Report, error) {
+	u.bufferMu.Lock()
+	batches, retry := u.buffer, u.retry
+	u.buffer, u.retry = nil, nil
+	u.bufferMu.Unlock()
+
+	r := &Report{Start: time.Now()}
+	if len(batches) == 0 && len(retry) == 0 {
+		return r, nil
+	}
+
+	failed, err := u.writePrimary(ctx, r, retry)
+	u.requeue(failed)
+
+	failed, newErr := u.writeBatches(ctx, r, batches)
+	u.requeue(failed)
+
+	if err == nil {
+		err = newErr
+	}
+
+	r.Elapsed = time.Since(r.Start)
+
+	if err != nil {
+		u.LogErrorf("Flushing buffered points: %v", err)
+
+		if ctx.Err() == nil {
+			u.writeFailed()
+		}
+
+		return r, errors.Wrap(err, "flushing buffered points")
+	}
+
+	for _, err := range r.Errors {
+		u.LogErrorf("Flushing buffered points: %v", err)
+	}
+
+	u.failures = 0
+
+	return r, nil
+}
+
+// requeue keeps batches that InfluxDB did not accept, so the next flush retries
+// them. At most buffer_size points are kept; past that the oldest are dropped.
+func (u *InfluxUnifi) requeue(failed map[string]influx.BatchPoints) {
+	if len(failed) == 0 {
+		return
+	}
+
+	u.bufferMu.Lock()
+	defer u.bufferMu.Unlock()
+
+	if u.retry == nil {
+		u.retry = make(map[string]influx.BatchPoints)
+	}
+
+	total := 0
+
+	for key, bp := range failed {
+		if u.retry[key] == nil {
+			u.retry[key] = emptyBatch(bp)
+		}
+
+		u.retry[key].AddPoints(bp.Points())
+	}
+
+	keys := make([]string, 0, len(u.retry))
+
+	for key, bp := range u.retry {
+		keys = append(keys, key)
+		total += len(bp.Points())
+	}
+
+	if total <= u.BufferSize {
+		return
+	}
+
+	sort.Strings(keys)
+
+	drop := total - u.BufferSize
+	u.LogErrorf("InfluxDB write buffer is full (buffer_size: %d), dropped the %d oldest points that failed to write",
+		u.BufferSize, drop)
+
+	for _, key := range keys {
+		points := u.retry[key].Points()
+		n := drop
+
+		if n > len(points) {
+			n = len(points)
+		}
+
+		// Points are added in the order they were batched, so the oldest are first.
+		u.retry[key] = emptyBatch(u.retry[key])
+		u.retry[key].AddPoints(points[n:])
+
+		if drop -= n; drop == 0 {
+			return
+		}
+	}
+}
+
+// Flush fetches metrics and writes them to InfluxDB right away, along with any
+// buffered points, instead of waiting for the next interval. It waits for an
+// interval in progress to finish, so it is safe to call while PollController runs.
+// Without a poller collector, like after New(), only the buffered points are written,
+// and the report has only the written and failed counts. A failed buffer write is
+// returned when the new metrics were written.
+func (u *InfluxUnifi) Flush() (*Report, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	ctx := context.Background()
+
+	metrics, ok, err := u.fetchMetrics(ctx)
+	if errors.Is(err, errNoCollector) {
+		return u.writeBuffer(ctx)
+	}
+
+	if !ok {
+		_, _ = u.writeBuffer(ctx)
+
+		if err == nil {
+			return nil, errors.New("fetching metrics: no metrics returned")
+		}
+
+		return nil, errors.Wrap(err, "fetching metrics")
+	}
+
+	report, rErr := u.ReportMetricsContext(ctx, metrics)
+	if rErr != nil {
+		u.writeFailed()
+		_, _ = u.writeBuffer(ctx)
+
+		return report, rErr
+	}
+
+	report.error(err)
+
+	if _, bErr := u.writeBuffer(ctx); bErr != nil {
+		return report, bErr
+	}
+
+	return report, nil
+}
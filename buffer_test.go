@@ -0,0 +1,82 @@
+package influxunifi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golift.io/cnfg"
+)
+
+func TestBufferKeepsFailedPoints(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{FlushInterval: cnfg.Duration{Duration: time.Minute}})
+	mustReport(t, u, testMetrics(t))
+
+	buffered := 0
+	for _, bp := range u.buffer {
+		buffered += len(bp.Points())
+	}
+
+	if buffered == 0 {
+		t.Fatal("nothing was buffered")
+	}
+
+	w.err = errTestWrite
+	u.flushBuffer(context.Background())
+
+	if len(w.points("")) != 0 {
+		t.Fatal("points were written while the writer fails")
+	}
+
+	w.err = nil
+	u.flushBuffer(context.Background())
+
+	if written := len(w.points("")); written != buffered {
+		t.Errorf("wrote %d points after the failed flush, want the %d buffered", written, buffered)
+	}
+}
+
+func TestBufferSizeCap(t *testing.T) {
+	u, w, l := testUnifi(t, &Config{FlushInterval: cnfg.Duration{Duration: time.Minute}, BufferSize: 3})
+	w.err = errTestWrite
+
+	// More than buffer_size points flushes early; the failed points are kept, up to buffer_size.
+	if _, err := u.ReportMetrics(testMetrics(t)); err == nil {
+		t.Fatal("expected the early flush to fail")
+	}
+
+	kept := 0
+	for _, bp := range u.retry {
+		kept += len(bp.Points())
+	}
+
+	if kept != 3 {
+		t.Errorf("kept %d failed points, want buffer_size (3)", kept)
+	}
+
+	if l.count("write buffer is full") != 1 {
+		t.Error("dropping points past buffer_size was not logged")
+	}
+}
+
+func TestFlushWithoutCollector(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{FlushInterval: cnfg.Duration{Duration: time.Minute}})
+	u.Collector = &logCollector{}
+	mustReport(t, u, testMetrics(t))
+
+	w.err = errTestWrite
+	if _, err := u.Flush(); err == nil {
+		t.Error("Flush returned no error for a failed buffer write")
+	}
+
+	w.err = nil
+
+	r, err := u.Flush()
+	if err != nil {
+		t.Fatalf("Flush without a collector: %v", err)
+	}
+
+	if written := len(w.points("")); written == 0 || r.Written["writer"] != written {
+		t.Errorf("report written = %v, wrote %d points", r.Written, written)
+	}
+}
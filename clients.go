@@ -1,6 +1,9 @@
 package influxunifi
 
 import (
+	"hash/fnv"
+	"math"
+
 	"github.com/unifi-poller/unifi"
 )
 
@@ -18,7 +21,7 @@ func (u *InfluxUnifi) batchClient(r report, s *unifi.Client) { // nolint: funlen
 		"radio_name":  s.RadioName,
 		"radio":       s.Radio,
 		"radio_proto": s.RadioProto,
-		"name":        s.Name,
+		"name":        u.clientName(s),
 		"fixed_ip":    s.FixedIP,
 		"sw_port":     s.SwPort.Txt,
 		"os_class":    s.OsClass.Txt,
@@ -41,7 +44,6 @@ func (u *InfluxUnifi) batchClient(r report, s *unifi.Client) { // nolint: funlen
 		"channel":          s.Channel.Val,
 		"hostname":         s.Name,
 		"radio_desc":       s.RadioDescription,
-		"satisfaction":     s.Satisfaction.Val,
 		"bytes_r":          s.BytesR,
 		"ccq":              s.Ccq,
 		"noise":            s.Noise,
@@ -51,15 +53,12 @@ func (u *InfluxUnifi) batchClient(r report, s *unifi.Client) { // nolint: funlen
 		"rx_bytes":         s.RxBytes,
 		"rx_bytes_r":       s.RxBytesR,
 		"rx_packets":       s.RxPackets,
-		"rx_rate":          s.RxRate,
 		"signal":           s.Signal,
 		"tx_bytes":         s.TxBytes,
 		"tx_bytes_r":       s.TxBytesR,
 		"tx_packets":       s.TxPackets,
 		"tx_retries":       s.TxRetries,
 		"tx_power":         s.TxPower,
-		"tx_rate":          s.TxRate,
-		"uptime":           s.Uptime,
 		"wifi_tx_attempts": s.WifiTxAttempts,
 		"wired-rx_bytes":   s.WiredRxBytes,
 		"wired-rx_bytes-r": s.WiredRxBytesR,
@@ -69,29 +68,193 @@ func (u *InfluxUnifi) batchClient(r report, s *unifi.Client) { // nolint: funlen
 		"wired-tx_packets": s.WiredTxPackets,
 	}
 
+	// Older controllers do not report satisfaction. A zero would look like a bad score.
+	if s.Satisfaction.Txt != "" {
+		fields["satisfaction"] = s.Satisfaction.Val
+	}
+
+	// Negotiated link rates are only reported for wireless clients.
+	if s.RxRate != 0 || s.TxRate != 0 {
+		fields["rx_rate"] = s.RxRate
+		fields["tx_rate"] = s.TxRate
+	}
+
+	if s.AssocTime != 0 {
+		fields["assoc_time"] = s.AssocTime
+	}
+
+	// Some controllers only provide the association time; uptime is derived from it.
+	// An association time at or after the report time, from clock skew, gives no uptime.
+	if s.Uptime != 0 {
+		fields["uptime"] = s.Uptime
+	} else if uptime := r.metrics().TS.Unix() - s.AssocTime; s.AssocTime != 0 && uptime > 0 {
+		fields["uptime"] = uptime
+	}
+
+	// Wireless clients get a signal to noise ratio, when the AP reports noise.
+	if !s.IsWired.Val && s.Noise != 0 {
+		snr := s.Signal - s.Noise
+		fields["snr"] = snr
+
+		if u.WeakSignalThreshold > 0 {
+			fields["weak_signal"] = 0
+			if snr < int64(u.WeakSignalThreshold) {
+				fields["weak_signal"] = 1
+			}
+		}
+	}
+
+	// Wired clients plugged into a UniFi switch know where they're attached.
+	// The switch name and port are already tags.
+	if s.IsWired.Val && s.SwMac != "" {
+		tags["sw_mac"] = s.SwMac
+	}
+
 	r.send(&metric{Table: "clients", Tags: tags, Fields: fields})
 }
 
+// clientName returns the value for a client's name tag. The first non-empty
+// client_name_order field wins: name (the alias set in the controller), hostname or mac.
+// The default is only name, so clients without an alias keep an empty name tag, as
+// they always have. Set client_name_order to ["name", "hostname", "mac"] to fall back
+// to the hostname and mac; that renames the series of clients without an alias.
+// With client_name_mac enabled, the mac is always used, so a client that is renamed
+// does not start a new series.
+func (u *InfluxUnifi) clientName(s *unifi.Client) string {
+	if u.ClientNameMAC {
+		return s.Mac
+	}
+
+	for _, field := range u.ClientNameOrder {
+		var name string
+
+		switch field {
+		case "name":
+			name = s.Name
+		case "hostname":
+			name = s.Hostname
+		case "mac":
+			name = s.Mac
+		}
+
+		if name != "" {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// networkAllowed returns true if points for clients on a network should be recorded.
+// A network in exclude_networks is always dropped, even when it is also listed in networks.
+// Clients must pass the site filters too; a client on an allowed network at an excluded
+// site is still dropped.
+func (u *InfluxUnifi) networkAllowed(network string) bool {
+	if stringInSlice(network, u.ExcludeNetworks) {
+		return false
+	}
+
+	return len(u.Networks) == 0 || stringInSlice(network, u.Networks)
+}
+
+// clientFilter returns the reason a client is dropped, or "" if it passes every filter.
+// The reason is the name of the filter, and it's the same for the client's DPI table.
+func (u *InfluxUnifi) clientFilter(s *unifi.Client) string {
+	switch {
+	case !u.siteAllowed(s.SiteName):
+		return "sites"
+	case !u.networkAllowed(s.Network):
+		return "networks"
+	case !u.clientDeviceAllowed(s):
+		return "devices"
+	case !u.clientSampled(s.Mac):
+		return "client_sample_rate"
+	default:
+		return ""
+	}
+}
+
+// clientMACFilters returns the networks or devices filter reason for each client,
+// by normalized mac, or nil when neither filter is set. Clients that pass both have
+// an empty reason. DPI tables do not include a network or device, so they are
+// matched to clients by mac.
+func (u *InfluxUnifi) clientMACFilters(clients []*unifi.Client) map[string]string {
+	if len(u.Networks) == 0 && len(u.ExcludeNetworks) == 0 && len(u.devices) == 0 {
+		return nil
+	}
+
+	reasons := make(map[string]string, len(clients))
+
+	for _, s := range clients {
+		switch {
+		case !u.networkAllowed(s.Network):
+			reasons[normalizeMAC(s.Mac)] = "networks"
+		case !u.clientDeviceAllowed(s):
+			reasons[normalizeMAC(s.Mac)] = "devices"
+		default:
+			reasons[normalizeMAC(s.Mac)] = ""
+		}
+	}
+
+	return reasons
+}
+
+// dpiClientFilter returns the networks or devices filter reason for a DPI table's mac.
+// A DPI table for a client missing from the client list cannot be checked, so it is
+// dropped by the network filters if they are set, or else by the devices filter.
+func (u *InfluxUnifi) dpiClientFilter(reasons map[string]string, mac string) string {
+	if reasons == nil {
+		return ""
+	}
+
+	if reason, ok := reasons[normalizeMAC(mac)]; ok {
+		return reason
+	}
+
+	if len(u.Networks) > 0 || len(u.ExcludeNetworks) > 0 {
+		return "networks"
+	}
+
+	return "devices"
+}
+
+// clientSampled returns true if a client is in the client_sample_rate sample.
+// The mac is hashed, so the same clients are kept every interval.
+func (u *InfluxUnifi) clientSampled(mac string) bool {
+	if u.ClientSampleRate <= 0 || u.ClientSampleRate >= 1 {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(mac))
+
+	return float64(h.Sum32())/math.MaxUint32 < u.ClientSampleRate
+}
+
 // totalsDPImap: controller, site, name (app/cat name), dpi.
 type totalsDPImap map[string]map[string]map[string]unifi.DPIData
 
 func (u *InfluxUnifi) batchClientDPI(r report, s *unifi.DPITable, appTotal, catTotal totalsDPImap) {
 	for _, dpi := range s.ByApp {
-		category := unifi.DPICats.Get(dpi.Cat)
-		application := unifi.DPIApps.GetApp(dpi.Cat, dpi.App)
+		if u.dpiExcluded(dpi.Cat) {
+			r.dropped("dpi_exclude_categories", 1)
+			continue // Excluded categories are left out of the totals too.
+		}
+
+		category, application := u.dpiNames(dpi.Cat, dpi.App)
 		fillDPIMapTotals(appTotal, application, s.SourceName, s.SiteName, dpi)
 		fillDPIMapTotals(catTotal, category, s.SourceName, s.SiteName, dpi)
 
 		r.send(&metric{
 			Table: "clientdpi",
-			Tags: map[string]string{
+			Tags: u.dpiIDTags(map[string]string{
 				"category":    category,
 				"application": application,
 				"name":        s.Name,
 				"mac":         s.MAC,
 				"site_name":   s.SiteName,
 				"source":      s.SourceName,
-			},
+			}, dpi),
 			Fields: map[string]interface{}{
 				"tx_packets": dpi.TxPackets,
 				"rx_packets": dpi.RxPackets,
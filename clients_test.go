@@ -0,0 +1,181 @@
+package influxunifi
+
+import (
+	"testing"
+
+	"github.com/unifi-poller/unifi"
+)
+
+func TestClientDPINetworkFilter(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{ExcludeNetworks: []string{"Guest"}})
+	m := testMetrics(t)
+	allowed := m.ClientsDPI[0]
+
+	// A client on the excluded network, and a DPI table for a client the controller did not list.
+	guest := *m.Clients[0]
+	guest.Mac, guest.Network = "00:00:00:00:00:0a", "Guest"
+	m.Clients = append(m.Clients, &guest)
+
+	for _, mac := range []string{"00:00:00:00:00:0a", "00:00:00:00:00:0b"} {
+		dpi := *allowed
+		dpi.MAC = mac
+		m.ClientsDPI = append(m.ClientsDPI, &dpi)
+	}
+
+	mustReport(t, u, m)
+
+	points := w.points("clientdpi")
+	macs := make([]string, 0, len(points))
+
+	for _, p := range points {
+		if p.Tags()["mac"] != "TOTAL" {
+			macs = append(macs, p.Tags()["mac"])
+		}
+	}
+
+	if len(macs) != 1 || macs[0] != allowed.MAC {
+		t.Errorf("clientdpi written for %v, want only %s", macs, allowed.MAC)
+	}
+}
+
+func TestClientMACFiltersUnfiltered(t *testing.T) {
+	u, _, _ := testUnifi(t, nil)
+
+	if filters := u.clientMACFilters([]*unifi.Client{{Mac: "00:00:00:00:00:01"}}); filters != nil {
+		t.Errorf("filters = %v, want nil without filters", filters)
+	}
+}
+
+func TestClientDPIDevicesFilter(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{Devices: []string{"00-00-00-00-00-03"}})
+	m := testMetrics(t)
+	m.Clients[0].SwMac = "00:00:00:00:00:03"
+
+	// A client on another switch, and a DPI table for a client the controller did not list.
+	other := *m.Clients[0]
+	other.Mac, other.SwMac = "00:00:00:00:00:0a", "00:00:00:00:00:09"
+	m.Clients = append(m.Clients, &other)
+
+	for _, mac := range []string{"00:00:00:00:00:0a", "00:00:00:00:00:0b"} {
+		dpi := *m.ClientsDPI[0]
+		dpi.MAC = mac
+		m.ClientsDPI = append(m.ClientsDPI, &dpi)
+	}
+
+	mustReport(t, u, m)
+
+	for _, p := range w.points("clientdpi") {
+		if mac := p.Tags()["mac"]; mac != "TOTAL" && mac != m.Clients[0].Mac {
+			t.Errorf("clientdpi written for %s, a client not on an allowed device", mac)
+		}
+	}
+
+	if len(w.points("clientdpi")) == 0 {
+		t.Error("no clientdpi points for the client on the allowed device")
+	}
+}
+
+func TestClientDroppedReasons(t *testing.T) {
+	for name, test := range map[string]struct {
+		c      *Config
+		client func(*unifi.Client)
+	}{
+		"networks":           {&Config{ExcludeNetworks: []string{"Guest"}}, func(c *unifi.Client) { c.Network = "Guest" }},
+		"devices":            {&Config{Devices: []string{"00:00:00:00:00:03"}}, func(c *unifi.Client) { c.SwMac = "00:00:00:00:00:09" }},
+		"client_sample_rate": {&Config{ClientSampleRate: 0.000001}, func(*unifi.Client) {}},
+		"sites":              {&Config{ExcludeSites: []string{"default"}}, func(*unifi.Client) {}},
+	} {
+		u, _, _ := testUnifi(t, test.c)
+		m := testMetrics(t)
+		m.Devices, m.Sites, m.SitesDPI, m.IDSList = nil, nil, nil, nil
+		test.client(m.Clients[0])
+
+		r := mustReport(t, u, m)
+
+		// The client and its DPI table are dropped for the same reason.
+		if len(r.Dropped) != 1 || r.Dropped[name] != 2 {
+			t.Errorf("%s: dropped = %v, want %s=2", name, r.Dropped, name)
+		}
+	}
+}
+
+func TestClientWiredRatesUptime(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	m := testMetrics(t)
+	c := m.Clients[0]
+	c.IsWired.Val, c.Uptime, c.RxRate, c.TxRate = true, 0, 0, 0
+	c.AssocTime = testTime.Unix() - 300
+
+	mustReport(t, u, m)
+
+	points := w.points("clients")
+	if len(points) != 1 {
+		t.Fatalf("expected one client point, got %d", len(points))
+	}
+
+	fields := pointFields(t, points[0])
+
+	for _, field := range []string{"rx_rate", "tx_rate"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("wired client has a %s field", field)
+		}
+	}
+
+	if fields["uptime"] != int64(300) {
+		t.Errorf("uptime = %v, want 300 from assoc_time", fields["uptime"])
+	}
+
+	// The controller clock is ahead of the report time.
+	w.reset()
+	c.AssocTime = testTime.Unix() + 5
+	mustReport(t, u, m)
+
+	if uptime, ok := pointFields(t, w.points("clients")[0])["uptime"]; ok {
+		t.Errorf("uptime = %v for an association after the report time, want none", uptime)
+	}
+}
+
+func TestClientSwitchTags(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	m := testMetrics(t)
+	c := m.Clients[0]
+	c.IsWired.Val, c.SwMac, c.SwName = true, "00:00:00:00:00:03", "sw"
+	c.SwPort.Val, c.SwPort.Txt = 7, "7"
+
+	mustReport(t, u, m)
+
+	p := w.points("clients")[0]
+	tags, fields := p.Tags(), pointFields(t, p)
+
+	if tags["sw_mac"] != c.SwMac || tags["sw_name"] != "sw" || tags["sw_port"] != "7" {
+		t.Errorf("switch tags = %s, %s, %s", tags["sw_mac"], tags["sw_name"], tags["sw_port"])
+	}
+
+	// A field with the same key as a tag makes InfluxQL queries ambiguous.
+	for key := range fields {
+		if _, ok := tags[key]; ok {
+			t.Errorf("%s is both a tag and a field", key)
+		}
+	}
+}
+
+func TestClientNameOrder(t *testing.T) {
+	s := &unifi.Client{Mac: "00:00:00:00:00:01", Hostname: "laptop"}
+
+	tests := []struct {
+		order []string
+		want  string
+	}{
+		{order: nil, want: ""}, // The default keeps the old name tag.
+		{order: []string{"name", "hostname", "mac"}, want: "laptop"},
+		{order: []string{"name", "mac"}, want: s.Mac},
+	}
+
+	for _, test := range tests {
+		u, _, _ := testUnifi(t, &Config{ClientNameOrder: test.order})
+
+		if name := u.clientName(s); name != test.want {
+			t.Errorf("client_name_order %v: name = %q, want %q", test.order, name, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package influxunifi
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Counter reset modes.
+const (
+	counterResetNone  = "none"
+	counterResetCarry = "carry"
+)
+
+// counterWrap32 is where a 32-bit counter wraps back to zero.
+const counterWrap32 float64 = 1 << 32
+
+// counterPruneIntervals is how many intervals a series may go unseen before its counter state is discarded.
+const counterPruneIntervals = 10
+
+// counterSuffixes identify fields that count up until a device (or client session) restarts.
+var counterSuffixes = []string{ // nolint: gochecknoglobals
+	"bytes", "packets", "errors", "dropped", "retries", "crypts", "frags", "broadcast", "multicast",
+}
+
+// counterState holds the last seen counter values for every series.
+type counterState struct {
+	sync.Mutex
+	series map[string]*seriesCounters
+}
+
+// seriesCounters is the counter data for one measurement + tag set.
+type seriesCounters struct {
+	seen   time.Time // Local time, for pruning.
+	ts     time.Time // Point time, for rates.
+	last   map[string]float64
+	offset map[string]float64
+}
+
+// isWrap returns true if a counter that decreased from last probably wrapped around
+// at 32 bits, instead of being reset. Only counters near the 32-bit limit can wrap.
+func isWrap(last float64) bool {
+	return last >= counterWrap32/2 && last < counterWrap32
+}
+
+// isRateCounter returns true for the counters ComputeRates adds a _rate field for.
+func isRateCounter(field string) bool {
+	return strings.HasSuffix(field, "bytes") || strings.HasSuffix(field, "packets")
+}
+
+// isCounter returns true if a field name looks like a cumulative counter.
+// Rate fields, like rx_bytes-r, are not counters.
+func isCounter(field string) bool {
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(field, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seriesKey returns a unique string for a measurement and its tag set.
+func seriesKey(m *metric) string {
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	size := len(m.Table)
+	for k, v := range m.Tags {
+		size += len(k) + len(v) + 2 // nolint: gomnd
+	}
+
+	var b strings.Builder
+
+	b.Grow(size)
+	b.WriteString(m.Table)
+
+	for _, k := range keys {
+		b.WriteString(",")
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(m.Tags[k])
+	}
+
+	return b.String()
+}
+
+// toFloat returns the numeric value of a field, and false if it's not a number.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// fromFloat converts a float back into the same type as the original field value.
+func fromFloat(f float64, orig interface{}) interface{} {
+	switch orig.(type) {
+	case int64:
+		return int64(f)
+	case int:
+		return int(f)
+	case int32:
+		return int32(f)
+	case float32:
+		return float32(f)
+	default:
+		return f
+	}
+}
+
+// handleCounterResets keeps counter fields from going backward when a device reboots.
+// With CounterResetMode set to "carry" a counter that decreases is considered reset, and
+// the last value before the reset is carried forward as the new baseline. This keeps
+// rate() and derivative() queries from producing huge negative spikes after a reboot.
+// A counter must decrease by more than ResetThreshold to count as reset; smaller
+// decreases, like a controller reporting a slightly stale value, are written as is.
+// With DetectRollover, a counter that decreases from the top half of the 32-bit range
+// is considered wrapped, and 2^32 is carried forward instead. State for series that
+// stop reporting is pruned, so memory use follows the size of the network.
+// With ComputeRates, byte and packet counters also get a _rate field with the
+// per-second change since the last point, using the point timestamps, so a slow
+// poll or a replay does not skew it. The first time a series is seen, when a
+// counter decreases, and when the timestamp has not moved forward, there is no rate.
+func (u *InfluxUnifi) handleCounterResets(m *metric, ts time.Time) {
+	if u.CounterResetMode != counterResetCarry && !u.DetectRollover && !u.ComputeRates {
+		return
+	}
+
+	u.counters.Lock()
+	defer u.counters.Unlock()
+
+	if u.counters.series == nil {
+		u.counters.series = make(map[string]*seriesCounters)
+	}
+
+	key := seriesKey(m)
+	s := u.counters.series[key]
+
+	if s == nil {
+		s = &seriesCounters{last: make(map[string]float64), offset: make(map[string]float64)}
+		u.counters.series[key] = s
+	}
+
+	elapsed := ts.Sub(s.ts).Seconds()
+	s.seen, s.ts = time.Now(), ts
+
+	for field, val := range m.Fields {
+		raw, ok := toFloat(val)
+		if !ok || !isCounter(field) {
+			continue
+		}
+
+		if last, ok := s.last[field]; ok && last-raw > u.ResetThreshold {
+			switch {
+			case u.DetectRollover && isWrap(last):
+				s.offset[field] += counterWrap32
+			case u.CounterResetMode == counterResetCarry:
+				s.offset[field] += last
+			}
+		}
+
+		if u.ComputeRates && isRateCounter(field) {
+			if last, ok := s.last[field]; ok && raw >= last && elapsed > 0 {
+				m.Fields[field+"_rate"] = (raw - last) / elapsed
+			}
+		}
+
+		s.last[field] = raw
+
+		if s.offset[field] != 0 {
+			m.Fields[field] = fromFloat(raw+s.offset[field], val)
+		}
+	}
+}
+
+// pruneCounters removes counter state for series that have not been seen recently.
+func (u *InfluxUnifi) pruneCounters() {
+	u.counters.Lock()
+	defer u.counters.Unlock()
+
+	for key, s := range u.counters.series {
+		if time.Since(s.seen) > counterPruneIntervals*u.Interval.Duration {
+			delete(u.counters.series, key)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package influxunifi
+
+import (
+	"testing"
+	"time"
+)
+
+// counterMetric returns a usw metric for one device with an rx_bytes counter.
+func counterMetric(v int64) *metric {
+	return &metric{
+		Table:  "usw",
+		Tags:   map[string]string{"mac": "00:00:00:00:00:01"},
+		Fields: map[string]interface{}{"rx_bytes": v},
+	}
+}
+
+// counterRun feeds rx_bytes values for one device through handleCounterResets,
+// a minute apart, and returns the values that would be written.
+func counterRun(u *InfluxUnifi, values ...int64) []interface{} {
+	written := make([]interface{}, 0, len(values))
+
+	for i, v := range values {
+		m := counterMetric(v)
+		u.handleCounterResets(m, testTime.Add(time.Duration(i)*time.Minute))
+		written = append(written, m.Fields["rx_bytes"])
+	}
+
+	return written
+}
+
+func TestCounterResetCarry(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{CounterResetMode: counterResetCarry})
+
+	// The device reboots after 1500 and starts counting from zero again.
+	got := counterRun(u, 1000, 1500, 100, 300)
+	want := []interface{}{int64(1000), int64(1500), int64(1600), int64(1800)}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("interval %d: rx_bytes = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCounterResetTwice(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{CounterResetMode: counterResetCarry})
+
+	got := counterRun(u, 1000, 200, 50)
+	want := []interface{}{int64(1000), int64(1200), int64(1250)}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("interval %d: rx_bytes = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCounterResetThreshold(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{CounterResetMode: counterResetCarry, ResetThreshold: 100})
+
+	// A stale value 50 below the last one is not a reboot.
+	got := counterRun(u, 1500, 1450, 1600, 10)
+	want := []interface{}{int64(1500), int64(1450), int64(1600), int64(1610)}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("interval %d: rx_bytes = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCounterRollover(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{DetectRollover: true})
+
+	got := counterRun(u, int64(counterWrap32)-10, 5)
+	if want := int64(counterWrap32 + 5); got[1] != want {
+		t.Errorf("rx_bytes after wrap = %v, want %v", got[1], want)
+	}
+}
+
+func TestCounterResetOff(t *testing.T) {
+	u, _, _ := testUnifi(t, nil)
+
+	if got := counterRun(u, 1500, 100); got[1] != int64(100) {
+		t.Errorf("rx_bytes = %v, want 100 with counter_reset_mode unset", got[1])
+	}
+}
+
+func TestComputeRatesPointTime(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{ComputeRates: true})
+
+	first := counterMetric(1000)
+	u.handleCounterResets(first, testTime)
+
+	if _, ok := first.Fields["rx_bytes_rate"]; ok {
+		t.Error("the first observation has a rate")
+	}
+
+	// The rate follows the point times, not how long the poll took.
+	m := counterMetric(4000)
+	u.handleCounterResets(m, testTime.Add(30*time.Second))
+
+	if m.Fields["rx_bytes_rate"] != 100.0 {
+		t.Errorf("rx_bytes_rate = %v, want 100", m.Fields["rx_bytes_rate"])
+	}
+
+	// Same timestamp again, like a controller that has not updated its stats.
+	m = counterMetric(5000)
+	u.handleCounterResets(m, testTime.Add(30*time.Second))
+
+	if _, ok := m.Fields["rx_bytes_rate"]; ok {
+		t.Errorf("rx_bytes_rate = %v with no time elapsed, want none", m.Fields["rx_bytes_rate"])
+	}
+}
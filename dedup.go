@@ -0,0 +1,117 @@
+package influxunifi
+
+import (
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// dedupPoints merges points that share a measurement, tag set and timestamp into
+// one point. InfluxDB merges the fields of duplicate points too, so this only saves
+// write bandwidth. Timestamps are compared at the write precision, because points
+// that differ by less than that are duplicates once written. Collect workers batch
+// points in no particular order, so when duplicates have different values for the
+// same field, either value may be kept. Collapsed points are counted in the report.
+func (u *InfluxUnifi) dedupPoints(r *Report) {
+	if !u.Dedup {
+		return
+	}
+
+	precision := precisionDuration(u.Precision)
+	total := 0
+
+	for key, bp := range r.bp {
+		points, err := mergePoints(r, bp.Points(), precision)
+		if err != nil {
+			r.error(err)
+			continue
+		}
+
+		if dups := len(bp.Points()) - len(points); dups > 0 {
+			r.bp[key] = emptyBatch(bp)
+			r.bp[key].AddPoints(points)
+			r.Total -= dups
+			total += dups
+			r.dropped("dedup", dups)
+		}
+	}
+
+	if total > 0 {
+		u.Logf("Collapsed %d duplicate InfluxDB points", total)
+	}
+}
+
+// mergePoints returns points with the fields of duplicate points merged into the
+// first point with the same key. The report field count is updated.
+func mergePoints(r *Report, points []*influx.Point, precision time.Duration) ([]*influx.Point, error) {
+	first := make(map[string]int, len(points))
+	merged := make([]*influx.Point, 0, len(points))
+	fields := make(map[int]map[string]interface{})
+
+	for _, p := range points {
+		key := pointKey(p, precision)
+
+		i, ok := first[key]
+		if !ok {
+			first[key] = len(merged)
+			merged = append(merged, p)
+
+			continue
+		}
+
+		if fields[i] == nil {
+			f, err := merged[i].Fields()
+			if err != nil {
+				return nil, err
+			}
+
+			fields[i] = f
+		}
+
+		f, err := p.Fields()
+		if err != nil {
+			return nil, err
+		}
+
+		r.Fields -= len(f)
+
+		for k, v := range f {
+			if _, ok := fields[i][k]; !ok {
+				r.Fields++
+			}
+
+			fields[i][k] = v
+		}
+	}
+
+	for i, f := range fields {
+		p, err := influx.NewPoint(merged[i].Name(), merged[i].Tags(), f, merged[i].Time())
+		if err != nil {
+			return nil, err
+		}
+
+		merged[i] = p
+	}
+
+	return merged, nil
+}
+
+// pointKey returns a unique string for a point's measurement, tag set and
+// timestamp, with the timestamp truncated to the write precision.
+func pointKey(p *influx.Point, precision time.Duration) string {
+	return seriesKey(&metric{Table: p.Name(), Tags: p.Tags()}) + " " + p.Time().Truncate(precision).String()
+}
+
+// precisionDuration returns the duration of a precision setting, like s or ms.
+func precisionDuration(precision string) time.Duration {
+	switch precision {
+	case "us":
+		return time.Microsecond
+	case "ms":
+		return time.Millisecond
+	case "s":
+		return time.Second
+	default:
+		return time.Nanosecond
+	}
+}
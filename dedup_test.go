@@ -0,0 +1,69 @@
+package influxunifi
+
+import (
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+func dedupReport(t *testing.T, precision string, fields ...map[string]interface{}) *Report {
+	t.Helper()
+
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{Database: "unifi", Precision: precision})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Report{bp: map[string]influx.BatchPoints{"unifi": bp}}
+
+	for i, f := range fields {
+		// Each point is 300ms after the last; the same second, but not the same millisecond.
+		ts := testTime.Add(time.Duration(i) * 300 * time.Millisecond)
+
+		p, err := influx.NewPoint("uap", map[string]string{"mac": "00:00:00:00:00:01"}, f, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bp.AddPoint(p)
+		r.Total++
+		r.Fields += len(f)
+	}
+
+	return r
+}
+
+func TestDedupMergesFields(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{Dedup: true, Precision: "s"})
+	r := dedupReport(t, "s", map[string]interface{}{"a": 1.0, "b": 2.0}, map[string]interface{}{"b": 2.0, "c": 3.0})
+
+	u.dedupPoints(r)
+
+	points := r.bp["unifi"].Points()
+	if len(points) != 1 {
+		t.Fatalf("expected the duplicates to merge into one point, got %d", len(points))
+	}
+
+	fields := pointFields(t, points[0])
+	for _, f := range []string{"a", "b", "c"} {
+		if _, ok := fields[f]; !ok {
+			t.Errorf("merged point is missing field %s: %v", f, fields)
+		}
+	}
+
+	if r.Total != 1 || r.Fields != 3 || r.Dropped["dedup"] != 1 {
+		t.Errorf("total = %d, fields = %d, dropped = %v; want 1, 3 and dedup=1", r.Total, r.Fields, r.Dropped)
+	}
+}
+
+func TestDedupPrecision(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{Dedup: true, Precision: "ms"})
+	r := dedupReport(t, "ms", map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 2.0})
+
+	u.dedupPoints(r)
+
+	if n := len(r.bp["unifi"].Points()); n != 2 {
+		t.Errorf("points 300ms apart at ms precision are not duplicates; got %d points, want 2", n)
+	}
+}
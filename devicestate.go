@@ -0,0 +1,82 @@
+package influxunifi
+
+import (
+	"strings"
+
+	"github.com/unifi-poller/unifi"
+)
+
+// isIdle returns true if skip_idle_devices is enabled and a device has no
+// clients and no more throughput (bytes per second) than idle_bytes_r.
+// Only access points and switches are checked. Gateways are always exported.
+func (u *InfluxUnifi) isIdle(numSta, bytesR float64) bool {
+	return u.SkipIdleDevices && numSta == 0 && bytesR <= float64(u.IdleBytesR)
+}
+
+// isOffline returns true if include_offline is disabled and a device is disconnected.
+// Offline devices still report their last known stats, which are stale.
+// include_offline defaults to true, so offline devices are exported unless it's set false.
+func (u *InfluxUnifi) isOffline(state unifi.FlexInt) bool {
+	return u.IncludeOffline != nil && !*u.IncludeOffline && state.Val == 0
+}
+
+// batchStatePoint generates a lightweight device_state datapoint for a device
+// that is otherwise not exported. This lets a dashboard know the device is alive.
+// The device is counted as dropped in the report, with the reason.
+func batchStatePoint(r report, t map[string]string, state, uptime, lastSeen unifi.FlexInt, reason string) {
+	r.dropped(reason, 1)
+	r.send(&metric{
+		Table: "device_state",
+		Tags: map[string]string{
+			"mac":       t["mac"],
+			"site_name": t["site_name"],
+			"source":    t["source"],
+			"name":      t["name"],
+			"type":      t["type"],
+			"reason":    reason,
+		},
+		Fields: map[string]interface{}{
+			"state":     state.Val,
+			"uptime":    uptime.Val,
+			"last_seen": lastSeen.Val,
+		},
+	})
+}
+
+// normalizeMAC lowercases a mac address and removes separators, so
+// AA-BB-CC-DD-EE-FF, aa:bb:cc:dd:ee:ff and aabb.ccdd.eeff all match.
+func normalizeMAC(mac string) string {
+	return strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(mac))
+}
+
+// deviceWanted returns true if a device passes the site and devices filters.
+// Devices dropped by the devices filter are counted.
+func (u *InfluxUnifi) deviceWanted(r report, site, mac string) bool {
+	if !u.siteAllowed(site) {
+		r.dropped("sites", 1)
+		return false
+	}
+
+	if len(u.devices) > 0 && !u.devices[normalizeMAC(mac)] {
+		r.dropped("devices", 1)
+		return false
+	}
+
+	return true
+}
+
+// clientDeviceAllowed returns true if a client is listed in the devices filter,
+// or is attached to a listed access point, switch or gateway.
+func (u *InfluxUnifi) clientDeviceAllowed(s *unifi.Client) bool {
+	if len(u.devices) == 0 {
+		return true
+	}
+
+	for _, mac := range []string{s.Mac, s.ApMac, s.SwMac, s.GwMac} {
+		if mac != "" && u.devices[normalizeMAC(mac)] {
+			return true
+		}
+	}
+
+	return false
+}
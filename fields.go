@@ -0,0 +1,322 @@
+package influxunifi
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// fillFieldDefaults adds configured default values for fields a device omitted.
+// FieldDefaults keys are in the form measurement.field, ie. usw_ports.poe_power.
+// Fields that are present, even with a zero value, are never overwritten.
+func (u *InfluxUnifi) fillFieldDefaults(m *metric) {
+	for key, val := range u.FieldDefaults {
+		if !strings.HasPrefix(key, m.Table+".") {
+			continue
+		}
+
+		if field := strings.TrimPrefix(key, m.Table+"."); m.Fields[field] == nil {
+			m.Fields[field] = val
+		}
+	}
+}
+
+// renameFields applies the configured field renames to a metric.
+// FieldRenames keys are in the form measurement.field, like field_defaults.
+// A rename onto a field that already exists is skipped, so no data is overwritten.
+func (u *InfluxUnifi) renameFields(m *metric) {
+	for key, to := range u.FieldRenames {
+		if !strings.HasPrefix(key, m.Table+".") {
+			continue
+		}
+
+		from := strings.TrimPrefix(key, m.Table+".")
+
+		val, ok := m.Fields[from]
+		if !ok || from == to {
+			continue
+		}
+
+		if _, ok := m.Fields[to]; ok {
+			u.LogErrorf("InfluxDB field rename %s -> %s skipped: %s.%s already exists", key, to, m.Table, to)
+			continue
+		}
+
+		delete(m.Fields, from)
+		m.Fields[to] = val
+	}
+}
+
+// keepFields removes the fields not listed for a measurement in the fields setting.
+// Measurements without a list keep every field. Field names are matched after
+// field_renames are applied, so list the names that are written.
+func (u *InfluxUnifi) keepFields(m *metric) {
+	keep := u.Fields[m.Table]
+	if len(keep) == 0 {
+		return
+	}
+
+	for field := range m.Fields {
+		if !stringInSlice(field, keep) {
+			delete(m.Fields, field)
+		}
+	}
+}
+
+// forceFloats converts integer fields to floats. InfluxDB fixes a field's type
+// on the first write, so a field that is sometimes an int and sometimes a float
+// causes field type conflicts that reject the whole batch. force_float converts
+// every field, and force_float_fields converts only the listed measurement.field names.
+func (u *InfluxUnifi) forceFloats(m *metric) {
+	if !u.ForceFloat && len(u.ForceFloatFields) == 0 {
+		return
+	}
+
+	for field, val := range m.Fields {
+		if !u.ForceFloat && !stringInSlice(m.Table+"."+field, u.ForceFloatFields) {
+			continue
+		}
+
+		if f, ok := toFloat(val); ok {
+			m.Fields[field] = f
+		}
+	}
+}
+
+// deviceTables are the measurements bits_not_bytes applies to.
+var deviceTables = []string{ // nolint: gochecknoglobals
+	"uap", "uap_vaps", "uap_radios", "usg", "usg_wan_ports", "usg_networks", "usw", "usw_ports",
+}
+
+// addBitFields adds a bits field for every bytes field on a device measurement.
+// The new field is named by replacing bytes with bits, so rx_bytes-r gets rx_bits-r
+// and tx_bytes_rate gets tx_bits_rate. Every device field with bytes in its name
+// counts traffic; memory is reported in mem fields. With bits_only the bytes field
+// is removed, and only the bits field is written.
+func (u *InfluxUnifi) addBitFields(m *metric) {
+	if !u.BitsNotBytes || !stringInSlice(m.Table, deviceTables) {
+		return
+	}
+
+	for field, val := range m.Fields {
+		if !strings.Contains(field, "bytes") {
+			continue
+		}
+
+		f, ok := toFloat(val)
+		if !ok {
+			continue
+		}
+
+		m.Fields[strings.Replace(field, "bytes", "bits", 1)] = fromFloat(f*8, val) // nolint: gomnd
+
+		if u.BitsOnly {
+			delete(m.Fields, field)
+		}
+	}
+}
+
+// dropZeroFields removes numeric fields with a zero value from a metric.
+// Counters are checked for resets before this runs, so they still see zeros.
+func dropZeroFields(m *metric) {
+	for field, val := range m.Fields {
+		switch v := val.(type) {
+		case float64:
+			if v == 0 {
+				delete(m.Fields, field)
+			}
+		case float32:
+			if v == 0 {
+				delete(m.Fields, field)
+			}
+		case int:
+			if v == 0 {
+				delete(m.Fields, field)
+			}
+		case int64:
+			if v == 0 {
+				delete(m.Fields, field)
+			}
+		case int32:
+			if v == 0 {
+				delete(m.Fields, field)
+			}
+		case uint64:
+			if v == 0 {
+				delete(m.Fields, field)
+			}
+		}
+	}
+}
+
+// macPattern matches mac addresses separated with colons or dashes, or dotted in groups of four.
+var macPattern = regexp.MustCompile( // nolint: gochecknoglobals
+	`^([0-9A-Fa-f]{2}[:-]){5}[0-9A-Fa-f]{2}$|^([0-9A-Fa-f]{4}\.){2}[0-9A-Fa-f]{4}$`)
+
+// normalizeMACTags rewrites tag values that look like mac addresses to
+// lowercase with colons, so a firmware that changes the format does not
+// start new series. Any tag is checked, not just known mac tags.
+func (u *InfluxUnifi) normalizeMACTags(m *metric) {
+	if !u.NormalizeMACs {
+		return
+	}
+
+	for k, v := range m.Tags {
+		if !macPattern.MatchString(v) {
+			continue
+		}
+
+		hex := normalizeMAC(v)
+		m.Tags[k] = hex[0:2] + ":" + hex[2:4] + ":" + hex[4:6] + ":" + hex[6:8] + ":" + hex[8:10] + ":" + hex[10:12]
+	}
+}
+
+// Values for the invalid_floats setting.
+const (
+	invalidFloatsDrop = "drop"
+	invalidFloatsZero = "zero"
+)
+
+// sanitizeFloats drops (or zeroes) NaN and Inf field values.
+// InfluxDB rejects the entire write if a single field has one of these values.
+func (u *InfluxUnifi) sanitizeFloats(m *metric) {
+	for field, val := range m.Fields {
+		var f float64
+
+		switch v := val.(type) {
+		case float64:
+			f = v
+		case float32:
+			f = float64(v)
+		default:
+			continue
+		}
+
+		if !math.IsNaN(f) && !math.IsInf(f, 0) {
+			continue
+		}
+
+		u.Logf("InfluxDB field %s.%s has invalid value %v, action: %s", m.Table, field, f, u.InvalidFloats)
+
+		if u.InvalidFloats == invalidFloatsZero {
+			m.Fields[field] = float64(0)
+		} else {
+			delete(m.Fields, field)
+		}
+	}
+}
+
+// Values for the empty_tags setting.
+const (
+	emptyTagsDrop = "drop"
+	emptyTagsSkip = "skip"
+)
+
+// warnings remembers which warnings were logged, so they are logged only once.
+type warnings struct {
+	sync.Mutex
+	seen map[string]bool
+}
+
+// first returns true the first time it's called with a key.
+func (w *warnings) first(key string) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.seen[key] {
+		return false
+	}
+
+	if w.seen == nil {
+		w.seen = make(map[string]bool)
+	}
+
+	w.seen[key] = true
+
+	return true
+}
+
+// checkEmptyTags looks for tags with an empty value. InfluxDB silently drops these
+// tags, so two devices missing the same tag collapse into one series. With empty_tags
+// set to drop the tag is removed, and with skip the point is not written. Both log an
+// error, once for each measurement and tag. Returns false if the point should be skipped.
+func (u *InfluxUnifi) checkEmptyTags(m *metric) bool {
+	if u.EmptyTags == "" {
+		return true
+	}
+
+	for k, v := range m.Tags {
+		if v != "" {
+			continue
+		}
+
+		if u.warned.first(m.Table + "\x00" + k) {
+			u.LogErrorf("InfluxDB measurement %s has an empty %s tag, action: %s (logged once)", m.Table, k, u.EmptyTags)
+		}
+
+		if u.EmptyTags == emptyTagsSkip {
+			return false
+		}
+
+		delete(m.Tags, k)
+	}
+
+	return true
+}
+
+// transform runs the Transform hook. A panic in the hook is logged and drops the metric.
+func (u *InfluxUnifi) transform(m *metric) (out *metric) {
+	if u.Transform == nil {
+		return m
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			u.LogErrorf("Transform panic on %s: %v", m.Table, e)
+			out = nil
+		}
+	}()
+
+	return u.Transform(m)
+}
+
+// addStaticTags merges the configured static tags into a metric's tags.
+// Tags the metric already has win, unless tags_override is enabled.
+// controller_tag is always added as the controller tag. With several controllers,
+// controllers maps each controller URL (the source tag) to its own controller tag.
+// A controller missing from controllers, with no controller_tag, is tagged with its
+// URL. There is no default without either setting: the source tag already holds
+// the controller URL, and a copy of it would add a tag to every existing series.
+func (u *InfluxUnifi) addStaticTags(m *metric) {
+	for k, v := range u.Tags {
+		if _, ok := m.Tags[k]; !ok || u.TagsOverride {
+			m.Tags[k] = v
+		}
+	}
+
+	if u.ControllerTag != "" {
+		m.Tags["controller"] = u.ControllerTag
+	}
+
+	if len(u.Controllers) == 0 {
+		return
+	}
+
+	source := m.Tags["source"]
+	if name, ok := u.Controllers[source]; ok {
+		m.Tags["controller"] = name
+	} else if u.ControllerTag == "" && source != "" {
+		m.Tags["controller"] = source
+	}
+}
+
+// cleanStaticTags removes static tags with empty keys or values. InfluxDB discards them anyway.
+func (u *InfluxUnifi) cleanStaticTags() {
+	for k, v := range u.Tags {
+		if k == "" || v == "" {
+			u.LogErrorf("Ignoring InfluxDB static tag with an empty key or value: '%s=%s'", k, v)
+			delete(u.Tags, k)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package influxunifi
+
+import (
+	"math"
+	"testing"
+
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+)
+
+func TestSanitizeFloats(t *testing.T) {
+	for mode, want := range map[string]map[string]interface{}{
+		invalidFloatsDrop: {"ok": 1.5, "count": int64(3)},
+		invalidFloatsZero: {"ok": 1.5, "count": int64(3), "nan": 0.0, "inf": 0.0, "neg": 0.0},
+	} {
+		u, _, l := testUnifi(t, &Config{InvalidFloats: mode})
+		m := &metric{Table: "uap", Fields: map[string]interface{}{
+			"ok":    1.5,
+			"count": int64(3),
+			"nan":   math.NaN(),
+			"inf":   math.Inf(1),
+			"neg":   float32(math.Inf(-1)),
+		}}
+
+		u.sanitizeFloats(m)
+
+		if len(m.Fields) != len(want) {
+			t.Errorf("%s: fields = %v, want %v", mode, m.Fields, want)
+		}
+
+		for field, val := range want {
+			if m.Fields[field] != val {
+				t.Errorf("%s: %s = %v, want %v", mode, field, m.Fields[field], val)
+			}
+		}
+
+		if l.count("has invalid value") != 3 {
+			t.Errorf("%s: expected a log line for each invalid field", mode)
+		}
+	}
+}
+
+func TestDropZerosEmptyMetric(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{DropZeros: true})
+	r := mustReport(t, u, &poller.Metrics{TS: testTime, SitesDPI: []*unifi.DPITable{{
+		SiteName:   "default",
+		SourceName: "https://unifi",
+		ByApp:      []unifi.DPIData{{Cat: 5, App: 10}, {Cat: 5, App: 11, TxBytes: 100}},
+	}}})
+
+	points := w.points("sitedpi")
+	if len(points) != 1 {
+		t.Fatalf("expected the all-zero point to be skipped, got %d sitedpi points", len(points))
+	}
+
+	if fields := pointFields(t, points[0]); len(fields) != 1 || fields["tx_bytes"] != int64(100) {
+		t.Errorf("fields = %v, want only tx_bytes", fields)
+	}
+
+	if r.Dropped["drop_zeros"] != 1 {
+		t.Errorf("dropped = %v, want drop_zeros=1", r.Dropped)
+	}
+}
+
+// typeConflict mimics InfluxDB fixing a field's type on the first write, and
+// returns the first field written with a different type than before.
+func typeConflict(types map[string]string, m *metric) string {
+	for field, val := range m.Fields {
+		key, kind := m.Table+"."+field, "int"
+		if _, ok := val.(float64); ok {
+			kind = "float"
+		}
+
+		if types[key] == "" {
+			types[key] = kind
+		} else if types[key] != kind {
+			return key
+		}
+	}
+
+	return ""
+}
+
+func TestForceFloatConflict(t *testing.T) {
+	for name, c := range map[string]*Config{
+		"off":                {},
+		"force_float":        {ForceFloat: true},
+		"force_float_fields": {ForceFloatFields: []string{"usg.loadavg_1"}},
+	} {
+		u, _, _ := testUnifi(t, c)
+		types := make(map[string]string)
+		conflict := ""
+
+		// The load average is a whole number on the first poll.
+		for _, val := range []interface{}{int64(1), 1.25} {
+			m := &metric{Table: "usg", Fields: map[string]interface{}{"loadavg_1": val}}
+			u.forceFloats(m)
+
+			if key := typeConflict(types, m); key != "" {
+				conflict = key
+			}
+		}
+
+		if name == "off" && conflict != "usg.loadavg_1" {
+			t.Errorf("%s: expected a field type conflict on usg.loadavg_1, got %q", name, conflict)
+		} else if name != "off" && conflict != "" {
+			t.Errorf("%s: unexpected field type conflict on %s", name, conflict)
+		}
+	}
+}
+
+func TestControllerTag(t *testing.T) {
+	for name, test := range map[string]struct {
+		c      *Config
+		source string
+		want   string
+	}{
+		"unset":          {&Config{}, "https://unifi", ""},
+		"controller_tag": {&Config{ControllerTag: "hq"}, "https://unifi", "hq"},
+		"mapped":         {&Config{Controllers: map[string]string{"https://unifi": "hq"}}, "https://unifi", "hq"},
+		"unmapped":       {&Config{Controllers: map[string]string{"https://unifi": "hq"}}, "https://other", "https://other"},
+		"unmapped default": {
+			&Config{ControllerTag: "lab", Controllers: map[string]string{"https://unifi": "hq"}}, "https://other", "lab",
+		},
+	} {
+		u, _, _ := testUnifi(t, test.c)
+		m := &metric{Table: "usw", Tags: map[string]string{"source": test.source}}
+
+		if u.addStaticTags(m); m.Tags["controller"] != test.want {
+			t.Errorf("%s: controller = %q, want %q", name, m.Tags["controller"], test.want)
+		}
+	}
+}
+
+func TestNormalizeMACTags(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{NormalizeMACs: true})
+	m := &metric{Table: "clients", Tags: map[string]string{
+		"mac":    "AA-BB-CC-DD-EE-0F",
+		"ap_mac": "AA:BB:CC:DD:EE:0F",
+		"sw_mac": "aabb.ccdd.ee0f",
+		"name":   "not-a-mac",
+		"serial": "AABBCCDDEE0F",
+	}}
+
+	u.normalizeMACTags(m)
+
+	for _, tag := range []string{"mac", "ap_mac", "sw_mac"} {
+		if m.Tags[tag] != "aa:bb:cc:dd:ee:0f" {
+			t.Errorf("%s = %q, want aa:bb:cc:dd:ee:0f", tag, m.Tags[tag])
+		}
+	}
+
+	if m.Tags["name"] != "not-a-mac" || m.Tags["serial"] != "AABBCCDDEE0F" {
+		t.Errorf("tags that are not macs were changed: %v", m.Tags)
+	}
+}
+
+func TestCheckEmptyTags(t *testing.T) {
+	u, _, l := testUnifi(t, &Config{EmptyTags: emptyTagsDrop})
+
+	for i := 0; i < 3; i++ {
+		m := &metric{Table: "usw", Tags: map[string]string{"name": "", "mac": "00:00:00:00:00:01"}}
+
+		if !u.checkEmptyTags(m) {
+			t.Fatal("empty_tags drop skipped the point")
+		}
+
+		if _, ok := m.Tags["name"]; ok || m.Tags["mac"] == "" {
+			t.Errorf("tags = %v, want only the empty tag removed", m.Tags)
+		}
+	}
+
+	u.checkEmptyTags(&metric{Table: "uap", Tags: map[string]string{"name": ""}})
+
+	// One warning for each measurement and tag, not one for every point.
+	if n := len(l.errors); n != 2 || l.count("has an empty name tag") != 2 {
+		t.Errorf("logged %d errors, want one for usw and one for uap: %v", n, l.errors)
+	}
+
+	u.EmptyTags = emptyTagsSkip
+	if u.checkEmptyTags(&metric{Table: "usw", Tags: map[string]string{"name": ""}}) {
+		t.Error("empty_tags skip kept the point")
+	}
+}
+
+func TestTwoControllersSeparated(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{
+		Dedup:       true,
+		Controllers: map[string]string{"https://unifi": "hq", "https://branch": "branch"},
+	})
+
+	// Both controllers have the same default site, switch and client.
+	m, branch := testMetrics(t), testMetrics(t)
+	branch.Sites[0].SourceName = "https://branch"
+	branch.Clients[0].SourceName = "https://branch"
+	branch.Devices.USWs[0].SourceName = "https://branch"
+	m.Sites = append(m.Sites, branch.Sites...)
+	m.Clients = append(m.Clients, branch.Clients...)
+	m.Devices.USWs = append(m.Devices.USWs, branch.Devices.USWs...)
+
+	r := mustReport(t, u, m)
+
+	for _, table := range []string{"subsystems", "clients", "usw"} {
+		controllers := make(map[string]bool)
+
+		for _, p := range w.points(table) {
+			controllers[p.Tags()["controller"]] = true
+		}
+
+		if len(controllers) != 2 || !controllers["hq"] || !controllers["branch"] {
+			t.Errorf("%s points have controller tags %v, want hq and branch", table, controllers)
+		}
+	}
+
+	if r.Dropped["dedup"] != 0 {
+		t.Errorf("dedup merged %d points from different controllers", r.Dropped["dedup"])
+	}
+}
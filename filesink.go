@@ -0,0 +1,62 @@
+package influxunifi
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+)
+
+// fileSink appends line protocol to output_file. When the file would grow past
+// output_file_max_size bytes it is renamed with a timestamp suffix and a new file
+// is started, so rotated files can be shipped and imported later.
+type fileSink struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+}
+
+// write appends a batch of points to the file, rotating it first if needed.
+func (f *fileSink) write(bp influx.BatchPoints) error {
+	f.Lock()
+	defer f.Unlock()
+
+	b := lineProtocol(bp)
+
+	if err := f.rotate(int64(b.Len())); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640) // nolint: gomnd
+	if err != nil {
+		return errors.Wrap(err, "opening output_file")
+	}
+	defer file.Close()
+
+	if _, err := b.WriteTo(file); err != nil {
+		return errors.Wrap(err, "writing output_file")
+	}
+
+	return nil
+}
+
+// rotate renames the file if adding size bytes would make it too large.
+func (f *fileSink) rotate(size int64) error {
+	if f.maxSize <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil || info.Size() == 0 || info.Size()+size <= f.maxSize {
+		return nil // A missing file is created by write.
+	}
+
+	rotated := f.path + "." + time.Now().Format("20060102T150405.000")
+	if err := os.Rename(f.path, rotated); err != nil {
+		return errors.Wrap(err, "rotating output_file")
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package influxunifi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/unifi-poller/unifi"
+)
+
+// flapTracker remembers switch port link states between intervals.
+type flapTracker struct {
+	sync.Mutex
+	switches map[string]*switchPorts
+}
+
+// switchPorts is the port link state and flap count for one switch, keyed by port index.
+type switchPorts struct {
+	seen   time.Time // Local time, for pruning.
+	uptime float64
+	up     map[string]bool
+	flaps  map[string]int
+}
+
+// trackPortFlaps counts down-to-up port transitions on a switch and returns the total.
+// The counts start over when the switch's uptime goes backward (it rebooted).
+func (u *InfluxUnifi) trackPortFlaps(s *unifi.USW) int {
+	u.flaps.Lock()
+	defer u.flaps.Unlock()
+
+	if u.flaps.switches == nil {
+		u.flaps.switches = make(map[string]*switchPorts)
+	}
+
+	key := flapKey(s.SourceName, s.Mac)
+	sw := u.flaps.switches[key]
+
+	if sw == nil || s.Uptime.Val < sw.uptime {
+		sw = &switchPorts{up: make(map[string]bool), flaps: make(map[string]int)}
+		u.flaps.switches[key] = sw
+	}
+
+	sw.seen, sw.uptime = time.Now(), s.Uptime.Val
+	total := 0
+
+	for _, p := range s.PortTable {
+		if wasUp, ok := sw.up[p.PortIdx.Txt]; ok && !wasUp && p.Up.Val {
+			sw.flaps[p.PortIdx.Txt]++
+		}
+
+		sw.up[p.PortIdx.Txt] = p.Up.Val
+		total += sw.flaps[p.PortIdx.Txt]
+	}
+
+	return total
+}
+
+// portFlaps returns the flap count for a switch port, and false if the switch is not tracked.
+func (u *InfluxUnifi) portFlaps(source, mac, port string) (int, bool) {
+	u.flaps.Lock()
+	defer u.flaps.Unlock()
+
+	sw := u.flaps.switches[flapKey(source, mac)]
+	if sw == nil {
+		return 0, false
+	}
+
+	return sw.flaps[port], true
+}
+
+// pruneFlaps removes port state for switches that have not been seen recently,
+// like switches that were removed or replaced.
+func (u *InfluxUnifi) pruneFlaps() {
+	u.flaps.Lock()
+	defer u.flaps.Unlock()
+
+	for key, sw := range u.flaps.switches {
+		if time.Since(sw.seen) > counterPruneIntervals*u.Interval.Duration {
+			delete(u.flaps.switches, key)
+		}
+	}
+}
+
+func flapKey(source, mac string) string {
+	return source + "\x00" + mac
+}
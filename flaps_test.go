@@ -0,0 +1,53 @@
+package influxunifi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/unifi-poller/unifi"
+)
+
+// flapUSW returns a switch with one port in the provided link state.
+func flapUSW(source, mac string, up bool) *unifi.USW {
+	s := &unifi.USW{SourceName: source, Mac: mac, PortTable: []unifi.Port{{}}}
+	s.Uptime.Val = 100
+	s.PortTable[0].PortIdx.Txt = "1"
+	s.PortTable[0].Up.Val = up
+
+	return s
+}
+
+func TestPortFlapsKeyedBySourceAndMAC(t *testing.T) {
+	u, _, _ := testUnifi(t, nil)
+
+	// Without a separator, these two switches share a key.
+	u.trackPortFlaps(flapUSW("https://unifi", "1:00", false))
+	u.trackPortFlaps(flapUSW("https://unifi1", ":00", true))
+
+	if flaps := u.trackPortFlaps(flapUSW("https://unifi", "1:00", true)); flaps != 1 {
+		t.Errorf("flaps = %d, want 1", flaps)
+	}
+
+	if flaps, ok := u.portFlaps("https://unifi1", ":00", "1"); !ok || flaps != 0 {
+		t.Errorf("other switch flaps = %d, %v, want 0, true", flaps, ok)
+	}
+}
+
+func TestPruneFlaps(t *testing.T) {
+	u, _, _ := testUnifi(t, nil)
+	u.trackPortFlaps(flapUSW("https://unifi", "00:00:00:00:00:03", true))
+	u.trackPortFlaps(flapUSW("https://unifi", "00:00:00:00:00:04", true))
+
+	u.flaps.switches[flapKey("https://unifi", "00:00:00:00:00:04")].seen =
+		time.Now().Add(-(counterPruneIntervals + 1) * u.Interval.Duration)
+
+	u.pruneFlaps()
+
+	if _, ok := u.portFlaps("https://unifi", "00:00:00:00:00:03", "1"); !ok {
+		t.Error("a switch seen this interval was pruned")
+	}
+
+	if _, ok := u.portFlaps("https://unifi", "00:00:00:00:00:04", "1"); ok {
+		t.Error("a switch not seen for many intervals was kept")
+	}
+}
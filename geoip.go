@@ -0,0 +1,82 @@
+package influxunifi
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/pkg/errors"
+)
+
+// geoIP holds the optional MaxMind databases used to enrich IDS alerts.
+type geoIP struct {
+	city *geoip2.Reader
+	asn  *geoip2.Reader
+}
+
+// openGeoIP opens the geoip_db (a City database) and geoip_asn_db files, if set.
+func (u *InfluxUnifi) openGeoIP() error {
+	if u.GeoIPDB == "" && u.GeoIPASNDB == "" {
+		return nil
+	}
+
+	u.geoip = &geoIP{}
+
+	var err error
+
+	if u.GeoIPDB != "" {
+		if u.geoip.city, err = geoip2.Open(u.GeoIPDB); err != nil {
+			return errors.Wrap(err, "opening geoip_db")
+		}
+	}
+
+	if u.GeoIPASNDB != "" {
+		if u.geoip.asn, err = geoip2.Open(u.GeoIPASNDB); err != nil {
+			return errors.Wrap(err, "opening geoip_asn_db")
+		}
+	}
+
+	return nil
+}
+
+// close closes the geoip databases.
+func (g *geoIP) close() {
+	if g == nil {
+		return
+	}
+
+	if g.city != nil {
+		_ = g.city.Close()
+	}
+
+	if g.asn != nil {
+		_ = g.asn.Close()
+	}
+}
+
+// addGeoTags adds src_country, src_city and asn tags for an IP address.
+// Failed lookups, and lookups with empty results, leave the tags out.
+func (u *InfluxUnifi) addGeoTags(tags map[string]string, addr string) {
+	ip := net.ParseIP(addr)
+	if u.geoip == nil || ip == nil {
+		return
+	}
+
+	if u.geoip.city != nil {
+		if city, err := u.geoip.city.City(ip); err == nil {
+			if city.Country.IsoCode != "" {
+				tags["src_country"] = city.Country.IsoCode
+			}
+
+			if name := city.City.Names["en"]; name != "" {
+				tags["src_city"] = name
+			}
+		}
+	}
+
+	if u.geoip.asn != nil {
+		if asn, err := u.geoip.asn.ASN(ip); err == nil && asn.AutonomousSystemNumber != 0 {
+			tags["asn"] = strconv.FormatUint(uint64(asn.AutonomousSystemNumber), 10)
+		}
+	}
+}
@@ -4,7 +4,10 @@ go 1.14
 
 require (
 	github.com/influxdata/influxdb1-client v0.0.0-20200515024757-02f0bf5dbca3
+	github.com/oschwald/geoip2-golang v1.4.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.6.0
+	github.com/prometheus/common v0.10.0
 	github.com/prometheus/procfs v0.1.2 // indirect
 	github.com/unifi-poller/poller v0.0.6
 	github.com/unifi-poller/unifi v0.0.5
@@ -0,0 +1,122 @@
+package influxunifi
+
+import (
+	"math"
+
+	"github.com/unifi-poller/unifi"
+)
+
+// Health score inputs. These are the keys used in the HealthWeights config map.
+const (
+	healthUptime      = "uptime"
+	healthUtilization = "utilization"
+	healthErrors      = "errors"
+	healthTemperature = "temperature"
+)
+
+// These define what healthy looks like. See healthScore() for how they're used.
+const (
+	healthUptimeFull = 24 * 60 * 60 // seconds until uptime stops hurting the score.
+	healthErrorsZero = 0.01         // error+drop to packet ratio that scores zero.
+	healthTempGood   = 50.0         // celsius, at or below this scores full.
+	healthTempBad    = 90.0         // celsius, at or above this scores zero.
+	healthMaxScore   = 100
+)
+
+// defaultHealthWeights are used for any weight missing from the HealthWeights config.
+func defaultHealthWeights() map[string]float64 {
+	return map[string]float64{
+		healthUptime:      1,
+		healthUtilization: 2,
+		healthErrors:      2,
+		healthTemperature: 1,
+	}
+}
+
+// deviceHealth holds the raw inputs used to compute a device health score.
+type deviceHealth struct {
+	Uptime  float64
+	CPU     float64
+	Mem     float64
+	Errors  float64 // errors plus dropped packets.
+	Packets float64
+	Temp    float64 // zero if the device has no sensor.
+}
+
+// healthScore returns a 0-100 health gauge for a device. Each input is scored 0 to 1:
+//
+//	uptime:      uptime / 1 day, so a recently rebooted device scores lower.
+//	utilization: 1 - the higher of cpu% and mem%.
+//	errors:      1 - (errors+drops / packets) / 1%, so a 1% error rate scores zero.
+//	temperature: 1 at 50C and below, falling to 0 at 90C. Skipped if not reported.
+//
+// The result is the weighted average of the scores, times 100.
+func (u *InfluxUnifi) healthScore(h deviceHealth) float64 {
+	scores := map[string]float64{
+		healthUptime:      h.Uptime / healthUptimeFull,
+		healthUtilization: 1 - math.Max(h.CPU, h.Mem)/healthMaxScore,
+		healthErrors:      1,
+	}
+
+	if h.Packets > 0 {
+		scores[healthErrors] = 1 - h.Errors/h.Packets/healthErrorsZero
+	}
+
+	if h.Temp > 0 {
+		scores[healthTemperature] = (healthTempBad - h.Temp) / (healthTempBad - healthTempGood)
+	}
+
+	var total, weights float64
+
+	for input, score := range scores {
+		total += u.HealthWeights[input] * math.Max(0, math.Min(1, score))
+		weights += u.HealthWeights[input]
+	}
+
+	if weights == 0 {
+		return 0
+	}
+
+	return math.Round(total / weights * healthMaxScore)
+}
+
+// apHealth returns the error and packet counts used for access point health.
+func apHealth(ap *unifi.Ap) (errors, packets float64) {
+	if ap == nil {
+		return 0, 0
+	}
+
+	return ap.RxErrors.Val + ap.TxErrors.Val + ap.RxDropped.Val + ap.TxDropped.Val,
+		ap.RxPackets.Val + ap.TxPackets.Val
+}
+
+// swHealth returns the error and packet counts used for switch health.
+func swHealth(sw *unifi.Sw) (errors, packets float64) {
+	if sw == nil {
+		return 0, 0
+	}
+
+	return sw.RxErrors.Val + sw.TxErrors.Val + sw.RxDropped.Val + sw.TxDropped.Val,
+		sw.RxPackets.Val + sw.TxPackets.Val
+}
+
+// gwHealth returns the error and packet counts used for gateway health.
+func gwHealth(gw *unifi.Gw) (errors, packets float64) {
+	if gw == nil {
+		return 0, 0
+	}
+
+	return gw.WanRxErrors.Val + gw.WanRxDropped.Val + gw.LanRxDropped.Val,
+		gw.WanRxPackets.Val + gw.WanTxPackets.Val + gw.LanRxPackets.Val + gw.LanTxPackets.Val
+}
+
+// maxTemp returns the hottest temperature sensor reading.
+func maxTemp(temps []unifi.Temperature) float64 {
+	max := 0.0
+
+	for _, t := range temps {
+		max = math.Max(max, t.Value)
+	}
+
+	return max
+}
@@ -0,0 +1,226 @@
+package influxunifi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+)
+
+// testLogger keeps every log message, so tests can look for warnings.
+type testLogger struct {
+	mu     sync.Mutex
+	info   []string
+	errors []string
+}
+
+func (l *testLogger) Logf(m string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.info = append(l.info, fmt.Sprintf(m, v...))
+}
+
+func (l *testLogger) LogErrorf(m string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(m, v...))
+}
+
+func (l *testLogger) LogDebugf(m string, v ...interface{}) {}
+
+// count returns how many info and error messages contain s.
+func (l *testLogger) count(s string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+
+	for _, msg := range append(append([]string{}, l.info...), l.errors...) {
+		if strings.Contains(msg, s) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// testWriter is a Writer that keeps every batch it is given.
+type testWriter struct {
+	mu      sync.Mutex
+	err     error
+	batches []influx.BatchPoints
+}
+
+func (w *testWriter) WritePoints(_ context.Context, bp influx.BatchPoints) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	w.batches = append(w.batches, bp)
+
+	return nil
+}
+
+// points returns the written points for a measurement, or every point without a name.
+func (w *testWriter) points(name string) []*influx.Point {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var points []*influx.Point
+
+	for _, bp := range w.batches {
+		for _, p := range bp.Points() {
+			if name == "" || p.Name() == name {
+				points = append(points, p)
+			}
+		}
+	}
+
+	return points
+}
+
+// reset forgets the batches written so far.
+func (w *testWriter) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.batches = nil
+}
+
+// testUnifi returns an InfluxUnifi with config defaults applied, that writes to a testWriter.
+func testUnifi(t testing.TB, c *Config) (*InfluxUnifi, *testWriter, *testLogger) {
+	t.Helper()
+
+	if c == nil {
+		c = &Config{}
+	}
+
+	l, w := &testLogger{}, &testWriter{}
+	u := &InfluxUnifi{Logger: l, Writer: w, InfluxDB: &InfluxDB{Config: c}, reload: make(chan struct{}, 1)}
+	u.setConfigDefaults()
+
+	return u, w, l
+}
+
+// mustReport runs ReportMetrics and fails the test on an error.
+func mustReport(t testing.TB, u *InfluxUnifi, m *poller.Metrics) *Report {
+	t.Helper()
+
+	r, err := u.ReportMetrics(m)
+	if err != nil {
+		t.Fatalf("ReportMetrics: %v", err)
+	}
+
+	return r
+}
+
+// pointFields returns a point's fields, and fails the test if they cannot be read.
+func pointFields(t testing.TB, p *influx.Point) map[string]interface{} {
+	t.Helper()
+
+	fields, err := p.Fields()
+	if err != nil {
+		t.Fatalf("reading fields of %s: %v", p.Name(), err)
+	}
+
+	return fields
+}
+
+// testTime is the metrics timestamp used in tests.
+var testTime = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC) // nolint: gochecknoglobals
+
+// sinkReport is a report that keeps only the last metric sent, for benchmarks.
+type sinkReport struct {
+	m    *poller.Metrics
+	last *metric
+	sent int
+}
+
+func (r *sinkReport) add()                                 {}
+func (r *sinkReport) done()                                {}
+func (r *sinkReport) send(m *metric)                       { r.last = m; r.sent++ }
+func (r *sinkReport) error(err error)                      {}
+func (r *sinkReport) dropped(reason string, count int)     {}
+func (r *sinkReport) batch(string, *metric, *influx.Point) {}
+func (r *sinkReport) metrics() *poller.Metrics             { return r.m }
+func (r *sinkReport) start() time.Time                     { return r.m.TS }
+
+// failClient is an InfluxDB client whose writes always fail. It counts the attempts.
+type failClient struct {
+	influx.Client
+	mu       sync.Mutex
+	attempts int
+}
+
+func (c *failClient) Write(influx.BatchPoints) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+
+	return errTestWrite
+}
+
+// errTestWrite is returned by the test clients and writers that fail.
+var errTestWrite = fmt.Errorf("test write failure") // nolint: gochecknoglobals
+
+// testCollector is a poller collector that returns the same metrics every time.
+type testCollector struct {
+	testLogger
+	metrics *poller.Metrics
+	err     error
+}
+
+func (c *testCollector) Metrics() (*poller.Metrics, bool, error) {
+	return c.metrics, c.metrics != nil, c.err
+}
+
+func (c *testCollector) MetricsFrom(*poller.Filter) (*poller.Metrics, bool, error) {
+	return c.Metrics()
+}
+
+// testMetrics returns one of each kind of metric: a site, site and client DPI,
+// a client, an IDS event and a switch, all in the default site.
+func testMetrics(t testing.TB) *poller.Metrics {
+	t.Helper()
+
+	m := &poller.Metrics{TS: testTime, Devices: &unifi.Devices{USWs: []*unifi.USW{{}}}}
+	site := &unifi.Site{}
+	client := &unifi.Client{}
+
+	for v, data := range map[interface{}]string{
+		site:              `{"name":"default","desc":"Default","health":[{"subsystem":"wan","status":"ok","num_user":5}]}`,
+		client:            `{"mac":"00:00:00:00:00:01","name":"laptop","network":"LAN","rx_bytes":100,"uptime":100,"last_seen":1000}`,
+		m.Devices.USWs[0]: `{"mac":"00:00:00:00:00:03","name":"sw","adopted":true,"state":1,"num_sta":5,"bytes":100}`,
+	} {
+		if err := json.Unmarshal([]byte(data), v); err != nil {
+			t.Fatalf("test metrics: %v", err)
+		}
+	}
+
+	for _, d := range []*struct{ source, site *string }{
+		{&site.SourceName, &site.SiteName},
+		{&client.SourceName, &client.SiteName},
+		{&m.Devices.USWs[0].SourceName, &m.Devices.USWs[0].SiteName},
+	} {
+		*d.source, *d.site = "https://unifi", "Default (default)"
+	}
+
+	m.Sites = unifi.Sites{site}
+	m.Clients = unifi.Clients{client}
+	m.IDSList = unifi.IDSList{{SourceName: "https://unifi", SiteName: "Default (default)", EventType: "alert"}}
+	m.SitesDPI = []*unifi.DPITable{{SourceName: "https://unifi", SiteName: "Default (default)",
+		ByApp: []unifi.DPIData{{Cat: 5, App: 10, TxBytes: 100}}}}
+	m.ClientsDPI = []*unifi.DPITable{{SourceName: "https://unifi", SiteName: "Default (default)",
+		MAC: "00:00:00:00:00:01", Name: "laptop", ByApp: []unifi.DPIData{{Cat: 5, App: 10, TxBytes: 100}}}}
+
+	return m
+}
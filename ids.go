@@ -38,5 +38,6 @@ func (u *InfluxUnifi) batchIDS(r report, i *unifi.IDS) {
 		"usgipASN":     i.UsgipASN,
 	}
 
-	r.send(&metric{Table: "intrusion_detect", Tags: tags, Fields: fields})
+	u.addGeoTags(tags, i.SrcIP)
+	r.send(&metric{Table: "intrusion_detect", Tags: tags, Fields: fields, TS: i.Datetime})
 }
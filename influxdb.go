@@ -3,37 +3,151 @@
 package influxunifi
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"os"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	influx "github.com/influxdata/influxdb1-client/v2"
 	"github.com/pkg/errors"
+	"github.com/prometheus/common/version"
 	"github.com/unifi-poller/poller"
 	"github.com/unifi-poller/unifi"
 	"golift.io/cnfg"
 )
 
+// Values for the protocol setting.
 const (
-	defaultInterval   = 30 * time.Second
-	minimumInterval   = 10 * time.Second
-	defaultInfluxDB   = "unifi"
-	defaultInfluxUser = "unifipoller"
-	defaultInfluxURL  = "http://127.0.0.1:8086"
+	protocolHTTP = "http"
+	protocolUDP  = "udp"
+)
+
+// Values for the timestamp_source setting.
+const (
+	timestampDevice = "device" // Use the time the metrics were collected.
+	timestampLocal  = "local"  // Use this host's clock when batching points.
+	timestampEvent  = "event"  // Use the event's own time; other points use device.
+)
+
+const (
+	defaultInterval       = 30 * time.Second
+	minimumInterval       = 10 * time.Second
+	defaultInfluxDB       = "unifi"
+	defaultInfluxUser     = "unifipoller"
+	defaultInfluxURL      = "http://127.0.0.1:8086"
+	defaultPrecision      = "s"
+	defaultRetryDelay     = time.Second
+	defaultStopTimeout    = 10 * time.Second
+	defaultWriteTimeout   = 10 * time.Second
+	defaultReconnectAfter = 3
+	defaultBatchSize      = 5000
+	defaultBufferBatches  = 20 // buffer_size defaults to this many batch_size chunks.
 )
 
 // Config defines the data needed to store metrics in InfluxDB.
 type Config struct {
-	Interval  cnfg.Duration `json:"interval,omitempty" toml:"interval,omitempty" xml:"interval" yaml:"interval"`
-	Disable   bool          `json:"disable" toml:"disable" xml:"disable,attr" yaml:"disable"`
-	VerifySSL bool          `json:"verify_ssl" toml:"verify_ssl" xml:"verify_ssl" yaml:"verify_ssl"`
-	URL       string        `json:"url,omitempty" toml:"url,omitempty" xml:"url" yaml:"url"`
-	User      string        `json:"user,omitempty" toml:"user,omitempty" xml:"user" yaml:"user"`
-	Pass      string        `json:"pass,omitempty" toml:"pass,omitempty" xml:"pass" yaml:"pass"`
-	DB        string        `json:"db,omitempty" toml:"db,omitempty" xml:"db" yaml:"db"`
+	Interval             cnfg.Duration          `json:"interval,omitempty" toml:"interval,omitempty" xml:"interval" yaml:"interval"`
+	FlushInterval        cnfg.Duration          `json:"flush_interval,omitempty" toml:"flush_interval,omitempty" xml:"flush_interval" yaml:"flush_interval"`
+	BufferSize           int                    `json:"buffer_size,omitempty" toml:"buffer_size,omitempty" xml:"buffer_size" yaml:"buffer_size"`
+	FlushOnStop          bool                   `json:"flush_on_stop" toml:"flush_on_stop" xml:"flush_on_stop" yaml:"flush_on_stop"`
+	StopTimeout          cnfg.Duration          `json:"stop_timeout,omitempty" toml:"stop_timeout,omitempty" xml:"stop_timeout" yaml:"stop_timeout"`
+	Disable              bool                   `json:"disable" toml:"disable" xml:"disable,attr" yaml:"disable"`
+	VerifySSL            bool                   `json:"verify_ssl" toml:"verify_ssl" xml:"verify_ssl" yaml:"verify_ssl"`
+	TLSCert              string                 `json:"tls_cert,omitempty" toml:"tls_cert,omitempty" xml:"tls_cert" yaml:"tls_cert"`
+	TLSKey               string                 `json:"tls_key,omitempty" toml:"tls_key,omitempty" xml:"tls_key" yaml:"tls_key"`
+	TLSCA                string                 `json:"tls_ca,omitempty" toml:"tls_ca,omitempty" xml:"tls_ca" yaml:"tls_ca"`
+	LogLevel             string                 `json:"log_level,omitempty" toml:"log_level,omitempty" xml:"log_level" yaml:"log_level"`
+	DryRun               bool                   `json:"dry_run" toml:"dry_run" xml:"dry_run" yaml:"dry_run"`
+	URL                  string                 `json:"url,omitempty" toml:"url,omitempty" xml:"url" yaml:"url"`
+	ProxyURL             string                 `json:"proxy_url,omitempty" toml:"proxy_url,omitempty" xml:"proxy_url" yaml:"proxy_url"`
+	User                 string                 `json:"user,omitempty" toml:"user,omitempty" xml:"user" yaml:"user"`
+	Pass                 string                 `json:"pass,omitempty" toml:"pass,omitempty" xml:"pass" yaml:"pass"`
+	Headers              map[string]string      `json:"headers,omitempty" toml:"headers,omitempty" xml:"headers" yaml:"headers"`
+	DB                   string                 `json:"db,omitempty" toml:"db,omitempty" xml:"db" yaml:"db"`
+	Org                  string                 `json:"org,omitempty" toml:"org,omitempty" xml:"org" yaml:"org"`
+	Bucket               string                 `json:"bucket,omitempty" toml:"bucket,omitempty" xml:"bucket" yaml:"bucket"`
+	Token                string                 `json:"token,omitempty" toml:"token,omitempty" xml:"token" yaml:"token"`
+	RetentionPolicy      string                 `json:"retention_policy,omitempty" toml:"retention_policy,omitempty" xml:"retention_policy" yaml:"retention_policy"`
+	RPRoutes             map[string]string      `json:"rp_routes,omitempty" toml:"rp_routes,omitempty" xml:"rp_routes" yaml:"rp_routes"`
+	TimestampSource      string                 `json:"timestamp_source,omitempty" toml:"timestamp_source,omitempty" xml:"timestamp_source" yaml:"timestamp_source"`
+	TimestampSources     map[string]string      `json:"timestamp_sources,omitempty" toml:"timestamp_sources,omitempty" xml:"timestamp_sources" yaml:"timestamp_sources"`
+	CreateDB             bool                   `json:"create_db" toml:"create_db" xml:"create_db" yaml:"create_db"`
+	Protocol             string                 `json:"protocol,omitempty" toml:"protocol,omitempty" xml:"protocol" yaml:"protocol"`
+	PayloadSize          int                    `json:"payload_size,omitempty" toml:"payload_size,omitempty" xml:"payload_size" yaml:"payload_size"`
+	Precision            string                 `json:"precision,omitempty" toml:"precision,omitempty" xml:"precision" yaml:"precision"`
+	CreateRP             bool                   `json:"create_rp" toml:"create_rp" xml:"create_rp" yaml:"create_rp"`
+	RPDuration           cnfg.Duration          `json:"rp_duration,omitempty" toml:"rp_duration,omitempty" xml:"rp_duration" yaml:"rp_duration"`
+	RPReplication        int                    `json:"rp_replication,omitempty" toml:"rp_replication,omitempty" xml:"rp_replication" yaml:"rp_replication"`
+	Consistency          string                 `json:"consistency,omitempty" toml:"consistency,omitempty" xml:"consistency" yaml:"consistency"`
+	WriteRetries         int                    `json:"write_retries,omitempty" toml:"write_retries,omitempty" xml:"write_retries" yaml:"write_retries"`
+	RetryDelay           cnfg.Duration          `json:"retry_delay,omitempty" toml:"retry_delay,omitempty" xml:"retry_delay" yaml:"retry_delay"`
+	SkipOverrun          bool                   `json:"skip_overrun" toml:"skip_overrun" xml:"skip_overrun" yaml:"skip_overrun"`
+	WriteTimeout         cnfg.Duration          `json:"write_timeout,omitempty" toml:"write_timeout,omitempty" xml:"write_timeout" yaml:"write_timeout"`
+	ReconnectAfter       int                    `json:"reconnect_after,omitempty" toml:"reconnect_after,omitempty" xml:"reconnect_after" yaml:"reconnect_after"`
+	MaxWritesPerSecond   float64                `json:"max_writes_per_second,omitempty" toml:"max_writes_per_second,omitempty" xml:"max_writes_per_second" yaml:"max_writes_per_second"`
+	Workers              int                    `json:"workers,omitempty" toml:"workers,omitempty" xml:"workers" yaml:"workers"`
+	BatchSize            int                    `json:"batch_size,omitempty" toml:"batch_size,omitempty" xml:"batch_size" yaml:"batch_size"`
+	OutputFile           string                 `json:"output_file,omitempty" toml:"output_file,omitempty" xml:"output_file" yaml:"output_file"`
+	OutputFileMaxSize    int64                  `json:"output_file_max_size,omitempty" toml:"output_file_max_size,omitempty" xml:"output_file_max_size" yaml:"output_file_max_size"`
+	OutputFileOnly       bool                   `json:"output_file_only" toml:"output_file_only" xml:"output_file_only" yaml:"output_file_only"`
+	Compress             bool                   `json:"compress" toml:"compress" xml:"compress" yaml:"compress"`
+	DisableSites         bool                   `json:"disable_sites" toml:"disable_sites" xml:"disable_sites" yaml:"disable_sites"`
+	SiteRollup           bool                   `json:"site_rollup" toml:"site_rollup" xml:"site_rollup" yaml:"site_rollup"`
+	DisableDPI           bool                   `json:"disable_dpi" toml:"disable_dpi" xml:"disable_dpi" yaml:"disable_dpi"`
+	DisableDPINames      bool                   `json:"disable_dpi_names" toml:"disable_dpi_names" xml:"disable_dpi_names" yaml:"disable_dpi_names"`
+	DPIIDTags            bool                   `json:"dpi_id_tags" toml:"dpi_id_tags" xml:"dpi_id_tags" yaml:"dpi_id_tags"`
+	DPIExcludeCategories []string               `json:"dpi_exclude_categories,omitempty" toml:"dpi_exclude_categories,omitempty" xml:"dpi_exclude_categories" yaml:"dpi_exclude_categories"`
+	DisableClients       bool                   `json:"disable_clients" toml:"disable_clients" xml:"disable_clients" yaml:"disable_clients"`
+	DisableIDS           bool                   `json:"disable_ids" toml:"disable_ids" xml:"disable_ids" yaml:"disable_ids"`
+	GeoIPDB              string                 `json:"geoip_db,omitempty" toml:"geoip_db,omitempty" xml:"geoip_db" yaml:"geoip_db"`
+	GeoIPASNDB           string                 `json:"geoip_asn_db,omitempty" toml:"geoip_asn_db,omitempty" xml:"geoip_asn_db" yaml:"geoip_asn_db"`
+	ProbeListen          string                 `json:"probe_listen,omitempty" toml:"probe_listen,omitempty" xml:"probe_listen" yaml:"probe_listen"`
+	StaleAfter           cnfg.Duration          `json:"stale_after,omitempty" toml:"stale_after,omitempty" xml:"stale_after" yaml:"stale_after"`
+	StartupCheck         string                 `json:"startup_check,omitempty" toml:"startup_check,omitempty" xml:"startup_check" yaml:"startup_check"`
+	Sites                []string               `json:"sites,omitempty" toml:"sites,omitempty" xml:"sites" yaml:"sites"`
+	ExcludeSites         []string               `json:"exclude_sites,omitempty" toml:"exclude_sites,omitempty" xml:"exclude_sites" yaml:"exclude_sites"`
+	Networks             []string               `json:"networks,omitempty" toml:"networks,omitempty" xml:"networks" yaml:"networks"`
+	ExcludeNetworks      []string               `json:"exclude_networks,omitempty" toml:"exclude_networks,omitempty" xml:"exclude_networks" yaml:"exclude_networks"`
+	ClientSampleRate     float64                `json:"client_sample_rate,omitempty" toml:"client_sample_rate,omitempty" xml:"client_sample_rate" yaml:"client_sample_rate"`
+	Devices              []string               `json:"devices,omitempty" toml:"devices,omitempty" xml:"devices" yaml:"devices"`
+	ControllerTag        string                 `json:"controller_tag,omitempty" toml:"controller_tag,omitempty" xml:"controller_tag" yaml:"controller_tag"`
+	NormalizeMACs        bool                   `json:"normalize_macs" toml:"normalize_macs" xml:"normalize_macs" yaml:"normalize_macs"`
+	Controllers          map[string]string      `json:"controllers,omitempty" toml:"controllers,omitempty" xml:"controllers" yaml:"controllers"`
+	Tags                 map[string]string      `json:"tags,omitempty" toml:"tags,omitempty" xml:"tags" yaml:"tags"`
+	TagsOverride         bool                   `json:"tags_override" toml:"tags_override" xml:"tags_override" yaml:"tags_override"`
+	EmptyTags            string                 `json:"empty_tags,omitempty" toml:"empty_tags,omitempty" xml:"empty_tags" yaml:"empty_tags"`
+	WeakSignalThreshold  int                    `json:"weak_signal_threshold,omitempty" toml:"weak_signal_threshold,omitempty" xml:"weak_signal_threshold" yaml:"weak_signal_threshold"`
+	ClientNameOrder      []string               `json:"client_name_order,omitempty" toml:"client_name_order,omitempty" xml:"client_name_order" yaml:"client_name_order"`
+	ClientNameMAC        bool                   `json:"client_name_mac" toml:"client_name_mac" xml:"client_name_mac" yaml:"client_name_mac"`
+	Dedup                bool                   `json:"dedup" toml:"dedup" xml:"dedup" yaml:"dedup"`
+	Fields               map[string][]string    `json:"fields,omitempty" toml:"fields,omitempty" xml:"fields" yaml:"fields"`
+	ForceFloat           bool                   `json:"force_float" toml:"force_float" xml:"force_float" yaml:"force_float"`
+	ForceFloatFields     []string               `json:"force_float_fields,omitempty" toml:"force_float_fields,omitempty" xml:"force_float_fields" yaml:"force_float_fields"`
+	DropZeros            bool                   `json:"drop_zeros" toml:"drop_zeros" xml:"drop_zeros" yaml:"drop_zeros"`
+	FieldDefaults        map[string]interface{} `json:"field_defaults,omitempty" toml:"field_defaults,omitempty" xml:"field_defaults" yaml:"field_defaults"`
+	FieldRenames         map[string]string      `json:"field_renames,omitempty" toml:"field_renames,omitempty" xml:"field_renames" yaml:"field_renames"`
+	BitsNotBytes         bool                   `json:"bits_not_bytes" toml:"bits_not_bytes" xml:"bits_not_bytes" yaml:"bits_not_bytes"`
+	BitsOnly             bool                   `json:"bits_only" toml:"bits_only" xml:"bits_only" yaml:"bits_only"`
+	MeasurementDB        map[string]string      `json:"measurement_db,omitempty" toml:"measurement_db,omitempty" xml:"measurement_db" yaml:"measurement_db"`
+	CounterResetMode     string                 `json:"counter_reset_mode,omitempty" toml:"counter_reset_mode,omitempty" xml:"counter_reset_mode" yaml:"counter_reset_mode"`
+	ResetThreshold       float64                `json:"reset_threshold,omitempty" toml:"reset_threshold,omitempty" xml:"reset_threshold" yaml:"reset_threshold"`
+	InvalidFloats        string                 `json:"invalid_floats,omitempty" toml:"invalid_floats,omitempty" xml:"invalid_floats" yaml:"invalid_floats"`
+	DetectRollover       bool                   `json:"detect_rollover" toml:"detect_rollover" xml:"detect_rollover" yaml:"detect_rollover"`
+	ComputeRates         bool                   `json:"compute_rates" toml:"compute_rates" xml:"compute_rates" yaml:"compute_rates"`
+	IntervalDeadline     cnfg.Duration          `json:"interval_deadline,omitempty" toml:"interval_deadline,omitempty" xml:"interval_deadline" yaml:"interval_deadline"`
+	HealthWeights        map[string]float64     `json:"health_weights,omitempty" toml:"health_weights,omitempty" xml:"health_weights" yaml:"health_weights"`
+	SkipIdleDevices      bool                   `json:"skip_idle_devices" toml:"skip_idle_devices" xml:"skip_idle_devices" yaml:"skip_idle_devices"`
+	IdleBytesR           int                    `json:"idle_bytes_r,omitempty" toml:"idle_bytes_r,omitempty" xml:"idle_bytes_r" yaml:"idle_bytes_r"`
+	IncludeOffline       *bool                  `json:"include_offline,omitempty" toml:"include_offline,omitempty" xml:"include_offline" yaml:"include_offline"`
+	CardinalityWarn      int                    `json:"cardinality_warn,omitempty" toml:"cardinality_warn,omitempty" xml:"cardinality_warn" yaml:"cardinality_warn"`
+	SeriesBudget         int                    `json:"series_budget,omitempty" toml:"series_budget,omitempty" xml:"series_budget" yaml:"series_budget"`
+	MeasurementPriority  []string               `json:"measurement_priority,omitempty" toml:"measurement_priority,omitempty" xml:"measurement_priority" yaml:"measurement_priority"`
+	VersionField         bool                   `json:"version_field" toml:"version_field" xml:"version_field" yaml:"version_field"`
+	Servers              []*Config              `json:"servers,omitempty" toml:"servers,omitempty" xml:"servers" yaml:"servers"`
 }
 
 // InfluxDB allows the data to be nested in the config file.
@@ -44,19 +158,62 @@ type InfluxDB struct {
 // InfluxUnifi is returned by New() after you provide a Config.
 type InfluxUnifi struct {
 	Collector poller.Collect
+	Logger    Logger
+	Writer    Writer
+	// OnReport, if set, is called by PollController after every interval is written,
+	// skipped, or fails. A failed write still has a report, with the Written and Failed
+	// counts; the report is nil when batching never started, like when the fetch fails.
+	// It runs after the poll lock is released, so it may call Flush or UpdateConfig.
+	OnReport func(r *Report, err error)
+	// Transform, if set, is called with each metric before it becomes a point, after
+	// every config option is applied. Return the metric, changed or not, or nil to drop it.
+	Transform func(m *Metric) *Metric
+	mu        sync.Mutex // Held while polling and flushing, so UpdateConfig waits.
+	reload    chan struct{}
 	influx    influx.Client
 	LastCheck time.Time
+	sessions  sessionTracker
+	counters  counterState
+	flaps     flapTracker
+	probe     probeState
+	warned    warnings
+	mirrors   []*mirror
+	bufferMu  sync.Mutex
+	buffer    map[string]influx.BatchPoints
+	retry     map[string]influx.BatchPoints // Buffered batches InfluxDB did not accept.
+	geoip     *geoIP
+	limiter   *writeLimiter
+	file      *fileSink
+	devices   map[string]bool
+	failures  int
+	overruns  int  // Consecutive intervals that took longer than the interval.
+	skipNext  bool // Set by skip_overrun after an overrun.
+	userFile  string
+	passFile  string
+	tokenFile string
 	*InfluxDB
 }
 
+// Logger receives log messages. It matches the poller's Logger, so a poller
+// Collect works, and a logrus or zap adapter only needs these three methods.
+type Logger interface {
+	Logf(m string, v ...interface{})
+	LogErrorf(m string, v ...interface{})
+	LogDebugf(m string, v ...interface{})
+}
+
+// Metric is a measurement before it becomes a point. Transform receives these.
+type Metric = metric
+
 type metric struct {
 	Table  string
 	Tags   map[string]string
 	Fields map[string]interface{}
+	TS     time.Time // When an event happened. Zero for everything else.
 }
 
 func init() { // nolint: gochecknoinits
-	u := &InfluxUnifi{InfluxDB: &InfluxDB{}, LastCheck: time.Now()}
+	u := &InfluxUnifi{InfluxDB: &InfluxDB{}, LastCheck: time.Now(), reload: make(chan struct{}, 1)}
 
 	poller.NewOutput(&poller.Output{
 		Name:   "influxdb",
@@ -68,51 +225,277 @@ func init() { // nolint: gochecknoinits
 // PollController runs forever, polling UniFi and pushing to InfluxDB
 // This is started by Run() or RunBoth() after everything checks out.
 func (u *InfluxUnifi) PollController() {
-	interval := u.Interval.Round(time.Second)
-	ticker := time.NewTicker(interval)
-	log.Printf("[INFO] Everything checks out! Poller started, InfluxDB interval: %v", interval)
+	u.PollControllerContext(context.Background())
+}
 
-	for u.LastCheck = range ticker.C {
-		metrics, ok, collectErr := u.Collector.Metrics()
-		if collectErr != nil {
-			u.Collector.LogErrorf("metric fetch for InfluxDB failed: %v", collectErr)
+// PollControllerContext polls UniFi and pushes to InfluxDB until the context is done.
+// An interval that is in progress is abandoned. With flush_on_stop enabled, one
+// final interval is written before returning, and buffered points are flushed;
+// stop_timeout bounds how long that takes. The probe_listen listener runs until
+// this returns.
+func (u *InfluxUnifi) PollControllerContext(ctx context.Context) {
+	interval, flushInterval := u.intervals()
+	ticker, flusher := time.NewTicker(interval), newFlusher(flushInterval)
+	stopProbe := u.startProbe()
 
-			if !ok {
-				continue
+	defer func() {
+		ticker.Stop()
+		flusher.stop()
+		stopProbe()
+	}()
+
+	u.Logf("Everything checks out! Poller started, InfluxDB interval: %v", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			stop, cancel := u.stopContext()
+
+			if u.FlushOnStop {
+				u.LastCheck = time.Now()
+				u.pollInterval(stop)
 			}
+
+			u.flushBuffer(stop)
+			cancel()
+			u.Logf("InfluxDB poller stopped: %v", ctx.Err())
+
+			return
+		case u.LastCheck = <-ticker.C:
+			u.pollInterval(ctx)
+		case <-flusher.c():
+			u.flushBuffer(ctx)
+		case <-u.reload:
+			interval, flushInterval = u.intervals()
+			ticker.Stop()
+			flusher.stop()
+			ticker, flusher = time.NewTicker(interval), newFlusher(flushInterval)
 		}
+	}
+}
 
-		report, err := u.ReportMetrics(metrics)
-		if err != nil {
-			// XXX: reset and re-auth? not sure..
-			u.Collector.LogErrorf("%v", err)
-			continue
+// intervals returns the poll and flush intervals.
+func (u *InfluxUnifi) intervals() (time.Duration, time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.Interval.Round(time.Second), u.FlushInterval.Duration
+}
+
+// stopContext returns the context for the final writes after the poller is stopped.
+// The poller's own context is already done, so this one only has the stop_timeout.
+func (u *InfluxUnifi) stopContext() (context.Context, context.CancelFunc) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return context.WithTimeout(context.Background(), u.StopTimeout.Duration)
+}
+
+// flusher ticks every flush_interval. A nil flusher never ticks.
+type flusher struct{ *time.Ticker }
+
+// newFlusher returns a flusher, or nil without a flush_interval.
+func newFlusher(interval time.Duration) *flusher {
+	if interval <= 0 {
+		return nil
+	}
+
+	return &flusher{time.NewTicker(interval)}
+}
+
+// c returns the ticker channel; nil (never ready) for a nil flusher.
+func (f *flusher) c() <-chan time.Time {
+	if f == nil {
+		return nil
+	}
+
+	return f.C
+}
+
+func (f *flusher) stop() {
+	if f != nil {
+		f.Stop()
+	}
+}
+
+// pollInterval fetches metrics and writes them to InfluxDB once. The fetch and
+// any write retries are abandoned when ctx is done, so a shutdown does not wait.
+// With an interval_deadline configured, the fetch, batching and write must all
+// finish within the deadline, so a slow InfluxDB cannot run into the next interval.
+// A write still running at the deadline is abandoned like any failed write; points
+// for ended sessions and counted flaps in it are not sent again. write_timeout
+// bounds each write attempt. OnReport is called after the poll lock is released,
+// every interval, even when it's skipped or the fetch fails.
+func (u *InfluxUnifi) pollInterval(parent context.Context) {
+	report, err := u.poll(parent)
+
+	if u.OnReport != nil {
+		u.OnReport(report, err)
+	}
+}
+
+// errIntervalSkipped is passed to OnReport for an interval skipped by skip_overrun.
+var errIntervalSkipped = errors.New("interval skipped to catch up after an overrun")
+
+// poll does the work for pollInterval while holding the poll lock.
+func (u *InfluxUnifi) poll(parent context.Context) (*Report, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.skipNext {
+		u.skipNext = false
+		u.LogErrorf("InfluxDB %v", errIntervalSkipped)
+
+		return nil, errIntervalSkipped
+	}
+
+	defer u.checkOverrun(time.Now())
+
+	ctx := parent
+
+	if u.IntervalDeadline.Duration > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, u.IntervalDeadline.Duration)
+		defer cancel()
+	}
+
+	metrics, ok, collectErr := u.fetchMetrics(ctx)
+	if !ok && collectErr == nil {
+		collectErr = errors.New("no metrics returned")
+	}
+
+	if collectErr != nil {
+		if errors.Is(collectErr, context.DeadlineExceeded) {
+			u.LogErrorf("InfluxDB interval deadline (%v) exceeded, skipped write: %v", u.IntervalDeadline, collectErr)
+		} else {
+			u.LogErrorf("metric fetch for InfluxDB failed: %v", collectErr)
+		}
+
+		if !ok {
+			u.probed(nil, collectErr)
+			return nil, errors.Wrap(collectErr, "fetching metrics")
+		}
+	}
+
+	report, err := u.ReportMetricsContext(ctx, metrics)
+	u.probed(report, err)
+	observeReport(report, err)
+
+	if err != nil {
+		if parent.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			u.LogErrorf("InfluxDB interval deadline (%v) exceeded, abandoned write: %v", u.IntervalDeadline, err)
+		} else {
+			u.LogErrorf("%v", err)
+		}
+
+		if parent.Err() == nil {
+			u.writeFailed() // A shutdown is not a reason to reconnect.
 		}
 
-		report.error(collectErr)
-		u.LogInfluxReport(report)
+		return report, err
+	}
+
+	u.failures = 0
+	report.error(collectErr)
+	u.LogInfluxReport(report)
+
+	return report, nil
+}
+
+// checkOverrun counts intervals that took longer than the interval to fetch and
+// write. The ticker drops the ticks that were missed, so an overrun only shows up
+// as fewer points; this logs it. With skip_overrun, the next interval is skipped
+// so the poller writes on schedule again instead of polling back to back.
+func (u *InfluxUnifi) checkOverrun(start time.Time) {
+	elapsed := time.Since(start)
+	if elapsed <= u.Interval.Duration {
+		u.overruns = 0
+		return
+	}
+
+	u.overruns++
+	u.skipNext = u.SkipOverrun
+	promOverruns.Inc()
+	u.LogErrorf("InfluxDB interval took %v, longer than the %v interval (%d in a row); "+
+		"increase the interval or reduce what is collected", elapsed.Round(time.Millisecond), u.Interval, u.overruns)
+}
+
+// writeFailed counts consecutive failed writes and rebuilds the InfluxDB
+// client once reconnect_after failures happen in a row. The rebuilt client
+// picks up a restarted server and any credentials rotated in a file:// path.
+func (u *InfluxUnifi) writeFailed() {
+	if u.failures++; u.DryRun || u.Writer != nil || u.failures < u.ReconnectAfter {
+		return
+	}
+
+	u.failures = 0
+	u.Logf("InfluxDB write failed %d times in a row, reconnecting to %s", u.ReconnectAfter, u.URL)
+
+	if u.userFile != "" {
+		u.User = u.getFromFile("Username", u.userFile)
+	}
+
+	if u.passFile != "" {
+		u.Pass = u.getFromFile("Password", u.passFile)
+	}
+
+	if u.tokenFile != "" {
+		u.Token = u.getFromFile("Token", u.tokenFile)
+	}
+
+	client, err := u.newClient(u.Logf)
+	if err != nil {
+		u.LogErrorf("Reconnecting to InfluxDB: %v", err)
+		return
+	}
+
+	if u.influx != nil {
+		_ = u.influx.Close()
+	}
+
+	u.influx = client
+	u.Logf("Reconnected to InfluxDB: %s", u.URL)
+}
+
+// fetchMetrics collects metrics from the poller, but gives up when the context is done.
+// The poller does not accept a context, so an abandoned fetch finishes in the background.
+func (u *InfluxUnifi) fetchMetrics(ctx context.Context) (*poller.Metrics, bool, error) {
+	type result struct {
+		metrics *poller.Metrics
+		ok      bool
+		err     error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		m, ok, err := u.Collector.Metrics()
+		done <- result{metrics: m, ok: ok, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.metrics, res.ok, res.err
+	case <-ctx.Done():
+		return nil, false, errors.Wrap(ctx.Err(), "fetching metrics")
 	}
 }
 
 // Run runs a ticker to poll the unifi server and update influxdb.
 func (u *InfluxUnifi) Run(c poller.Collect) error {
-	var err error
-
 	if u.Config == nil || u.Disable {
 		c.Logf("InfluxDB config missing (or disabled), InfluxDB output disabled!")
 		return nil
 	}
 
 	u.Collector = c
-	u.setConfigDefaults()
 
-	u.influx, err = influx.NewHTTPClient(influx.HTTPConfig{
-		Addr:      u.URL,
-		Username:  u.User,
-		Password:  u.Pass,
-		TLSConfig: &tls.Config{InsecureSkipVerify: !u.VerifySSL}, // nolint: gosec
-	})
-	if err != nil {
+	if err := u.setup(); err != nil {
+		return err
+	}
+
+	if err := u.startupCheck(); err != nil {
 		return err
 	}
 
@@ -121,23 +504,109 @@ func (u *InfluxUnifi) Run(c poller.Collect) error {
 	return nil
 }
 
+// setup applies config defaults, validates the config, creates the InfluxDB client
+// and provisions the server. The Collector must be set first; it provides the logger.
+func (u *InfluxUnifi) setup() error {
+	if err := u.prepare(); err != nil {
+		return err
+	}
+
+	u.provision()
+
+	return nil
+}
+
+// prepare applies config defaults, validates the config and opens the InfluxDB
+// clients and geoip databases. Call closeClients to release them. With a Writer
+// there is no InfluxDB client, so there is nothing to ping, and no create_db.
+func (u *InfluxUnifi) prepare() error {
+	var err error
+
+	u.setConfigDefaults()
+
+	if err = u.Validate(); err != nil {
+		return err
+	}
+
+	u.limiter = newWriteLimiter(u.MaxWritesPerSecond)
+
+	if u.OutputFile != "" {
+		u.file = &fileSink{path: u.OutputFile, maxSize: u.OutputFileMaxSize}
+	}
+
+	if u.DryRun {
+		u.Logf("InfluxDB dry run enabled, points are logged and not written to %s", u.URL)
+	} else if u.Writer != nil {
+		u.Logf("InfluxDB client not created, points are sent to the Writer")
+	} else if u.file != nil && u.OutputFileOnly {
+		u.Logf("InfluxDB points are written to %s only", u.OutputFile)
+	} else if u.influx, err = u.newClient(u.Logf); err != nil {
+		return err
+	}
+
+	if err = u.openGeoIP(); err != nil {
+		return err
+	}
+
+	return u.setupMirrors()
+}
+
+// provision checks that InfluxDB is reachable, and runs create_db and create_rp.
+func (u *InfluxUnifi) provision() {
+	u.pingInflux()
+	u.createDatabases()
+	u.createRetentionPolicies()
+}
+
+// checkProtocol returns a server protocol in lowercase. Unset and invalid protocols
+// are http; an invalid protocol is logged. name is the setting, for the log.
+func (u *InfluxUnifi) checkProtocol(name, protocol string) string {
+	switch protocol = strings.ToLower(protocol); protocol {
+	case "":
+		return protocolHTTP
+	case protocolHTTP, protocolUDP:
+		return protocol
+	default:
+		u.LogErrorf("Invalid InfluxDB %s '%s', using '%s'", name, protocol, protocolHTTP)
+		return protocolHTTP
+	}
+}
+
 func (u *InfluxUnifi) setConfigDefaults() {
+	u.checkLogLevel()
+	u.envDefaults()
+
 	if u.URL == "" {
 		u.URL = defaultInfluxURL
 	}
 
+	if strings.HasPrefix(u.User, "file://") {
+		u.userFile = strings.TrimPrefix(u.User, "file://")
+		u.User = u.getFromFile("Username", u.userFile)
+	}
+
 	if u.User == "" {
 		u.User = defaultInfluxUser
 	}
 
 	if strings.HasPrefix(u.Pass, "file://") {
-		u.Pass = u.getPassFromFile(strings.TrimPrefix(u.Pass, "file://"))
+		u.passFile = strings.TrimPrefix(u.Pass, "file://")
+		u.Pass = u.getFromFile("Password", u.passFile)
 	}
 
 	if u.Pass == "" {
 		u.Pass = defaultInfluxUser
 	}
 
+	if strings.HasPrefix(u.Token, "file://") {
+		u.tokenFile = strings.TrimPrefix(u.Token, "file://")
+		u.Token = u.getFromFile("Token", u.tokenFile)
+	}
+
+	if u.Token != "" && u.Bucket != "" {
+		u.DB = u.Bucket // InfluxDB 2.x writes to a bucket instead of a database.
+	}
+
 	if u.DB == "" {
 		u.DB = defaultInfluxDB
 	}
@@ -149,12 +618,163 @@ func (u *InfluxUnifi) setConfigDefaults() {
 	}
 
 	u.Interval = cnfg.Duration{Duration: u.Interval.Duration.Round(time.Second)}
+
+	if u.HealthWeights == nil {
+		u.HealthWeights = make(map[string]float64)
+	}
+
+	for input, weight := range defaultHealthWeights() {
+		if _, ok := u.HealthWeights[input]; !ok {
+			u.HealthWeights[input] = weight
+		}
+	}
+
+	u.cleanStaticTags()
+
+	if len(u.Devices) > 0 {
+		u.devices = make(map[string]bool, len(u.Devices))
+		for _, mac := range u.Devices {
+			u.devices[normalizeMAC(mac)] = true
+		}
+	}
+
+	if len(u.ClientNameOrder) == 0 {
+		u.ClientNameOrder = []string{"name"}
+	}
+
+	if u.Workers <= 0 {
+		u.Workers = runtime.GOMAXPROCS(0)
+	}
+
+	if u.ReconnectAfter <= 0 {
+		u.ReconnectAfter = defaultReconnectAfter
+	}
+
+	if u.BatchSize <= 0 {
+		u.BatchSize = defaultBatchSize
+	}
+
+	if u.BufferSize <= 0 {
+		u.BufferSize = defaultBufferBatches * u.BatchSize
+	}
+
+	if u.RetryDelay.Duration <= 0 {
+		u.RetryDelay = cnfg.Duration{Duration: defaultRetryDelay}
+	}
+
+	if u.StaleAfter.Duration <= 0 {
+		u.StaleAfter = cnfg.Duration{Duration: probeStaleIntervals * u.Interval.Duration}
+	}
+
+	if u.StopTimeout.Duration <= 0 {
+		u.StopTimeout = cnfg.Duration{Duration: defaultStopTimeout}
+	}
+
+	if u.WriteTimeout.Duration <= 0 {
+		u.WriteTimeout = cnfg.Duration{Duration: defaultWriteTimeout}
+	}
+
+	switch u.Precision {
+	case "":
+		u.Precision = defaultPrecision
+	case "ns", "us", "ms", "s":
+	default:
+		u.LogErrorf("Invalid InfluxDB precision '%s', using '%s'", u.Precision, defaultPrecision)
+		u.Precision = defaultPrecision
+	}
+
+	switch u.CounterResetMode = strings.ToLower(u.CounterResetMode); u.CounterResetMode {
+	case "":
+		u.CounterResetMode = counterResetNone
+	case counterResetNone, counterResetCarry:
+	default:
+		u.LogErrorf("Invalid InfluxDB counter_reset_mode '%s', using '%s'", u.CounterResetMode, counterResetNone)
+		u.CounterResetMode = counterResetNone
+	}
+
+	u.Protocol = u.checkProtocol("protocol", u.Protocol)
+
+	if u.Protocol == protocolUDP && (u.CreateDB || u.CreateRP) {
+		u.LogErrorf("InfluxDB create_db and create_rp do not work over UDP, disabled")
+		u.CreateDB, u.CreateRP = false, false
+	}
+
+	switch u.Consistency = strings.ToLower(u.Consistency); u.Consistency {
+	case "", "any", "one", "quorum", "all":
+	default:
+		u.LogErrorf("Invalid InfluxDB consistency '%s', using the server default", u.Consistency)
+		u.Consistency = ""
+	}
+
+	switch u.TimestampSource = strings.ToLower(u.TimestampSource); u.TimestampSource {
+	case "":
+		u.TimestampSource = timestampDevice
+	case timestampDevice, timestampLocal, timestampEvent:
+	default:
+		u.LogErrorf("Invalid InfluxDB timestamp_source '%s', using '%s'", u.TimestampSource, timestampDevice)
+		u.TimestampSource = timestampDevice
+	}
+
+	for table, source := range u.TimestampSources {
+		switch u.TimestampSources[table] = strings.ToLower(source); u.TimestampSources[table] {
+		case timestampDevice, timestampLocal, timestampEvent:
+		default:
+			u.LogErrorf("Invalid InfluxDB timestamp_sources '%s' for %s, using timestamp_source", source, table)
+			delete(u.TimestampSources, table)
+		}
+	}
+
+	switch u.InvalidFloats = strings.ToLower(u.InvalidFloats); u.InvalidFloats {
+	case "":
+		u.InvalidFloats = invalidFloatsDrop
+	case invalidFloatsDrop, invalidFloatsZero:
+	default:
+		u.LogErrorf("Invalid InfluxDB invalid_floats '%s', using '%s'", u.InvalidFloats, invalidFloatsDrop)
+		u.InvalidFloats = invalidFloatsDrop
+	}
+
+	switch u.StartupCheck = strings.ToLower(u.StartupCheck); u.StartupCheck {
+	case "":
+		u.StartupCheck = startupWarn
+	case startupWarn, startupAbort, startupOff:
+	default:
+		u.LogErrorf("Invalid InfluxDB startup_check '%s', using '%s'", u.StartupCheck, startupWarn)
+		u.StartupCheck = startupWarn
+	}
+
+	switch u.EmptyTags = strings.ToLower(u.EmptyTags); u.EmptyTags {
+	case "", emptyTagsDrop, emptyTagsSkip:
+	default:
+		u.LogErrorf("Invalid InfluxDB empty_tags '%s', empty tags are not checked", u.EmptyTags)
+		u.EmptyTags = ""
+	}
 }
 
-func (u *InfluxUnifi) getPassFromFile(filename string) string {
+// envDefaults fills empty connection settings from environment variables.
+// Precedence is: config value, environment variable, then the built-in default.
+// A file:// value works in either place.
+func (c *Config) envDefaults() {
+	for env, val := range map[string]*string{
+		"INFLUXDB_URL":      &c.URL,
+		"INFLUXDB_USER":     &c.User,
+		"INFLUXDB_PASSWORD": &c.Pass,
+		"INFLUXDB_TOKEN":    &c.Token,
+		"INFLUXDB_ORG":      &c.Org,
+		"INFLUXDB_BUCKET":   &c.Bucket,
+		"INFLUXDB_DB":       &c.DB,
+	} {
+		if *val == "" {
+			*val = os.Getenv(env)
+		}
+	}
+}
+
+// getFromFile returns the trimmed contents of a file holding a credential.
+// kind is only used in the error message, ie. Username, Password or Token.
+func (u *InfluxUnifi) getFromFile(kind, filename string) string {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
-		u.Collector.LogErrorf("Reading InfluxDB Password File: %v", err)
+		u.LogErrorf("Reading InfluxDB %s File: %v", kind, err)
 	}
 
 	return strings.TrimSpace(string(b))
@@ -162,41 +782,381 @@ func (u *InfluxUnifi) getPassFromFile(filename string) string {
 
 // ReportMetrics batches all device and client data into influxdb data points.
 // Call this after you've collected all the data you care about.
-// Returns an error if influxdb calls fail, otherwise returns a report.
+// Returns an error if influxdb calls fail. The report is returned with a write error,
+// so its Written and Failed counts show which servers got the points.
 func (u *InfluxUnifi) ReportMetrics(m *poller.Metrics) (*Report, error) {
-	r := &Report{Metrics: m, ch: make(chan *metric), Start: time.Now()}
+	return u.ReportMetricsContext(context.Background(), m)
+}
+
+// ReportMetricsContext is the same as ReportMetrics, but the writes to InfluxDB
+// are abandoned when the provided context is canceled or reaches its deadline.
+// A context that is already done returns before anything is batched. Batching
+// updates the session, flap and counter state, so it is never abandoned part way.
+func (u *InfluxUnifi) ReportMetricsContext(ctx context.Context, m *poller.Metrics) (*Report, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "batching points")
+	}
+
+	r := &Report{Metrics: m, ch: make(chan *metric), Start: time.Now(), bp: make(map[string]influx.BatchPoints)}
 	defer close(r.ch)
 
 	var err error
 
-	// Make a new Influx Points Batcher.
-	r.bp, err = influx.NewBatchPoints(influx.BatchPointsConfig{Database: u.DB})
+	// Make a new Influx Points Batcher for each database and retention policy.
+	for _, db := range u.databases() {
+		for _, rp := range u.retentionPolicies() {
+			r.bp[batchKey(db, rp)], err = influx.NewBatchPoints(influx.BatchPointsConfig{
+				Database:         db,
+				Precision:        u.Precision,
+				RetentionPolicy:  rp,
+				WriteConsistency: u.Consistency,
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "influx.NewBatchPoint")
+			}
+		}
+	}
 
-	if err != nil {
-		return nil, errors.Wrap(err, "influx.NewBatchPoint")
+	for i := 0; i < u.Workers; i++ {
+		go u.collect(r, r.ch)
 	}
 
-	go u.collect(r, r.ch)
 	// Batch all the points.
 	u.loopPoints(r)
 	r.wg.Wait() // wait for all points to finish batching!
+	u.pruneCounters()
+	u.pruneFlaps()
+	u.dedupPoints(r)
 
-	// Send all the points.
-	if err = u.influx.Write(r.bp); err != nil {
-		return nil, errors.Wrap(err, "influxdb.Write(points)")
+	series, total := countSeries(r)
+	r.Series = total
+	u.warnCardinality(series, total)
+	u.enforceSeriesBudget(r, series, total)
+	r.error(u.batchSelfStats(r))
+
+	// Send all the points, or buffer them when flush_interval is set.
+	if u.FlushInterval.Duration > 0 {
+		err = u.bufferPoints(ctx, r)
+	} else {
+		_, err = u.writeBatches(ctx, r, r.bp)
 	}
 
 	r.Elapsed = time.Since(r.Start)
 
-	return r, nil
+	return r, err
+}
+
+// writeBatches writes batches to the InfluxDB server and any mirrors. A failed
+// write does not stop the other batches from being written. The batches the
+// InfluxDB server did not accept are returned with the first error.
+func (u *InfluxUnifi) writeBatches(ctx context.Context, r *Report,
+	batches map[string]influx.BatchPoints) (map[string]influx.BatchPoints, error) {
+	write := make(map[string]influx.BatchPoints, len(batches))
+
+	for key, bp := range batches {
+		if len(bp.Points()) == 0 && key != batchKey(u.DB, u.RetentionPolicy) {
+			continue
+		}
+
+		if u.DryRun {
+			u.logPoints(bp)
+			continue
+		}
+
+		if u.file != nil {
+			r.error(errors.Wrapf(u.file.write(bp), "db: %s", bp.Database()))
+
+			if u.OutputFileOnly {
+				continue
+			}
+		}
+
+		write[key] = bp
+	}
+
+	var (
+		wg     sync.WaitGroup
+		failed map[string]influx.BatchPoints
+		err    error
+	)
+
+	// The primary and every mirror are written at once, so a slow or failed
+	// server does not hold up the others. Each writes its batches in order.
+	wg.Add(1 + len(u.mirrors))
+
+	go func() {
+		defer wg.Done()
+		failed, err = u.writePrimary(ctx, r, write)
+	}()
+
+	for _, m := range u.mirrors {
+		go func(m *mirror) {
+			defer wg.Done()
+			u.writeMirror(ctx, r, m, write)
+		}(m)
+	}
+
+	wg.Wait()
+
+	return failed, err
+}
+
+// writePrimary writes batches to the InfluxDB server, or the Writer, and returns
+// the batches that failed with the first error.
+func (u *InfluxUnifi) writePrimary(ctx context.Context, r *Report,
+	batches map[string]influx.BatchPoints) (map[string]influx.BatchPoints, error) {
+	var (
+		writeErr error
+		failed   map[string]influx.BatchPoints
+	)
+
+	output, name := u.output()
+
+	for key, bp := range batches {
+		err := output.WritePoints(ctx, bp)
+		r.written(name, len(bp.Points()), err)
+		countWrite(name, len(bp.Points()), err)
+
+		if err == nil {
+			continue
+		}
+
+		if failed == nil {
+			failed = make(map[string]influx.BatchPoints)
+		}
+
+		failed[key] = bp
+
+		if err = errors.Wrapf(err, "influxdb.Write(points) db: %s", bp.Database()); writeErr == nil {
+			writeErr = err
+		} else {
+			r.error(err)
+		}
+	}
+
+	return failed, writeErr
+}
+
+// databases returns the default database and every database a measurement is routed to.
+func (u *InfluxUnifi) databases() []string {
+	dbs := []string{u.DB}
+
+	for _, db := range u.MeasurementDB {
+		if !stringInSlice(db, dbs) {
+			dbs = append(dbs, db)
+		}
+	}
+
+	return dbs
+}
+
+// measurementDB returns the database a measurement is written to.
+// Measurements not found in the MeasurementDB map go into the default database.
+func (u *InfluxUnifi) measurementDB(table string) string {
+	if db := u.MeasurementDB[table]; db != "" {
+		return db
+	}
+
+	return u.DB
+}
+
+// retentionPolicies returns the default retention policy and every policy a measurement is routed to.
+func (u *InfluxUnifi) retentionPolicies() []string {
+	rps := []string{u.RetentionPolicy}
+
+	for _, rp := range u.RPRoutes {
+		if !stringInSlice(rp, rps) {
+			rps = append(rps, rp)
+		}
+	}
+
+	return rps
+}
+
+// measurementRP returns the retention policy a measurement is written to.
+// Measurements not found in the RPRoutes map use the default retention policy.
+func (u *InfluxUnifi) measurementRP(table string) string {
+	if rp, ok := u.RPRoutes[table]; ok {
+		return rp
+	}
+
+	return u.RetentionPolicy
+}
+
+// measurementBatch returns the batch key for a measurement's database and retention policy.
+func (u *InfluxUnifi) measurementBatch(table string) string {
+	return batchKey(u.measurementDB(table), u.measurementRP(table))
+}
+
+// batchKey returns the key for a batch of points in a report.
+// Each database and retention policy pair gets its own batch and write.
+func batchKey(db, rp string) string {
+	return db + "\x00" + rp
+}
+
+// stringInSlice returns true if a string is in a slice.
+func stringInSlice(str string, slice []string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+// logPoints logs a batch of points as line protocol instead of writing them.
+func (u *InfluxUnifi) logPoints(bp influx.BatchPoints) {
+	for _, p := range bp.Points() {
+		u.Logf("[dry run] db: %s: %s", bp.Database(), p.PrecisionString(v1Precision(bp.Precision())))
+	}
+}
+
+// writeChunks writes a batch of points in chunks of at most batch_size points.
+// This keeps large deployments from sending one oversized write that InfluxDB rejects.
+func (u *InfluxUnifi) writeChunks(ctx context.Context, client influx.Client, bp influx.BatchPoints) error {
+	points := bp.Points()
+
+	for start := 0; start == 0 || start < len(points); start += u.BatchSize {
+		end := start + u.BatchSize
+		if end > len(points) {
+			end = len(points)
+		}
+
+		chunk := emptyBatch(bp)
+		chunk.AddPoints(points[start:end])
+
+		if err := u.write(ctx, client, chunk); err != nil {
+			return errors.Wrapf(err, "%d of %d points written before failure", start, len(points))
+		}
+	}
+
+	return nil
+}
+
+// write sends a batch of points to InfluxDB. Failed writes are retried up to
+// write_retries times, and the retry_delay doubles after every attempt, up to one
+// interval. If InfluxDB provides a Retry-After header, like a rate-limited InfluxDB
+// Cloud endpoint does, that duration (also up to one interval) is used instead, and
+// it is honored once even when retries are disabled. Retries stop if the context is canceled.
+func (u *InfluxUnifi) write(ctx context.Context, client influx.Client, bp influx.BatchPoints) error {
+	delay := u.RetryDelay.Duration
+
+	for attempt := 0; ; attempt++ {
+		if err := u.limiter.wait(ctx); err != nil {
+			return err
+		}
+
+		err := u.writeTimeout(ctx, client, bp)
+		if err == nil {
+			return nil
+		}
+
+		wait, retryAfter := delay, false
+
+		var wErr *writeError
+		if errors.As(err, &wErr) && wErr.RetryAfter > 0 {
+			wait, retryAfter = wErr.RetryAfter, true
+		}
+
+		if wait > u.Interval.Duration {
+			wait = u.Interval.Duration
+		}
+
+		if attempt >= u.WriteRetries && !(retryAfter && attempt == 0) {
+			return err
+		}
+
+		u.Logf("InfluxDB write failed (attempt %d), retrying after %v: %v", attempt+1, wait, err)
+
+		select {
+		case <-time.After(wait):
+			if delay *= 2; delay > u.Interval.Duration {
+				delay = u.Interval.Duration
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// writeTimeout writes a batch once, and gives up after write_timeout. This keeps a hung
+// InfluxDB server from stalling the poller, or holding up writes to the other servers.
+func (u *InfluxUnifi) writeTimeout(ctx context.Context, client influx.Client, bp influx.BatchPoints) error {
+	wctx, cancel := context.WithTimeout(ctx, u.WriteTimeout.Duration)
+	defer cancel()
+
+	err := writeContext(wctx, client, bp)
+	if err != nil && ctx.Err() == nil && errors.Is(wctx.Err(), context.DeadlineExceeded) {
+		return errors.Wrapf(err, "write timed out after %v", u.WriteTimeout)
+	}
+
+	return err
+}
+
+// writeContext writes a batch with a context if the client supports it.
+func writeContext(ctx context.Context, client influx.Client, bp influx.BatchPoints) error {
+	if c, ok := client.(contextWriter); ok {
+		return c.WriteContext(ctx, bp)
+	}
+
+	return client.Write(bp)
 }
 
 // collect runs in a go routine and batches all the points.
+// The workers setting controls how many of these run at once.
 func (u *InfluxUnifi) collect(r report, ch chan *metric) {
 	for m := range ch {
-		pt, err := influx.NewPoint(m.Table, m.Tags, m.Fields, r.metrics().TS)
+		u.fillFieldDefaults(m)
+		u.sanitizeFloats(m)
+		u.addStaticTags(m)
+		u.normalizeMACTags(m)
+
+		if !u.checkEmptyTags(m) {
+			r.dropped("empty_tags", 1)
+			r.done()
+
+			continue
+		}
+
+		u.handleCounterResets(m, u.pointTime(r, m))
+
+		if u.VersionField {
+			// Devices already have a version field (firmware), so this one is prefixed.
+			m.Fields["poller_version"] = version.Version
+		}
+
+		u.addBitFields(m)
+		u.renameFields(m)
+		u.keepFields(m)
+		u.forceFloats(m)
+
+		if len(m.Fields) == 0 && len(u.Fields[m.Table]) > 0 {
+			r.dropped("fields", 1) // Nothing in the field allowlist.
+			r.done()
+
+			continue
+		}
+
+		if u.DropZeros {
+			if dropZeroFields(m); len(m.Fields) == 0 {
+				r.dropped("drop_zeros", 1)
+				r.done()
+
+				continue
+			}
+		}
+
+		if m = u.transform(m); m == nil {
+			r.dropped("transform", 1)
+			r.done()
+
+			continue
+		}
+
+		pt, err := influx.NewPoint(m.Table, m.Tags, m.Fields, u.pointTime(r, m))
 		if err == nil {
-			r.batch(m, pt)
+			r.batch(u.measurementBatch(m.Table), m, pt)
 		}
 
 		r.error(err)
@@ -204,40 +1164,117 @@ func (u *InfluxUnifi) collect(r report, ch chan *metric) {
 	}
 }
 
+// pointTime returns the timestamp for a point. timestamp_sources overrides
+// timestamp_source for the listed measurements.
+func (u *InfluxUnifi) pointTime(r report, m *metric) time.Time {
+	source, ok := u.TimestampSources[m.Table]
+	if !ok {
+		source = u.TimestampSource
+	}
+
+	switch {
+	case source == timestampEvent && !m.TS.IsZero():
+		return m.TS
+	case source == timestampLocal:
+		return r.start() // The controller clock may be wrong. One time for the whole report.
+	default:
+		return r.metrics().TS
+	}
+}
+
 // loopPoints kicks off 3 or 7 go routines to process metrics and send them
 // to the collect routine through the metric channel.
 func (u *InfluxUnifi) loopPoints(r report) {
 	m := r.metrics()
+	sites := u.newRollups()
 
-	for _, s := range m.SitesDPI {
-		u.batchSiteDPI(r, s)
+	if !u.DisableSites {
+		for _, s := range m.Sites {
+			if u.siteAllowed(s.SiteName) {
+				u.batchSite(r, s)
+			} else {
+				r.dropped("sites", 1)
+			}
+		}
 	}
 
-	for _, s := range m.Sites {
-		u.batchSite(r, s)
+	if !u.DisableDPI {
+		u.loopDPIPoints(r)
 	}
 
-	appTotal := make(totalsDPImap)
-	catTotal := make(totalsDPImap)
+	if !u.DisableClients || sites != nil {
+		clients := make([]*unifi.Client, 0, len(m.Clients))
 
-	for _, s := range m.ClientsDPI {
-		u.batchClientDPI(r, s, appTotal, catTotal)
+		for _, s := range m.Clients {
+			if u.siteAllowed(s.SiteName) {
+				sites.client(s)
+			}
+
+			if u.DisableClients {
+				continue
+			} else if reason := u.clientFilter(s); reason != "" {
+				r.dropped(reason, 1)
+				continue
+			}
+
+			clients = append(clients, s)
+			u.batchClient(r, s)
+		}
+
+		if !u.DisableClients {
+			u.batchClientSessions(r, clients)
+		}
 	}
 
-	reportClientDPItotals(r, appTotal, catTotal)
+	if !u.DisableIDS {
+		for _, s := range m.IDSList {
+			if u.siteAllowed(s.SiteName) {
+				u.batchIDS(r, s)
+			} else {
+				r.dropped("sites", 1)
+			}
+		}
+	}
+
+	u.loopDevicePoints(r, sites)
+	batchSiteRollups(r, sites)
+}
+
+func (u *InfluxUnifi) loopDPIPoints(r report) {
+	m := r.metrics()
 
-	for _, s := range m.Clients {
-		u.batchClient(r, s)
+	for _, s := range m.SitesDPI {
+		if u.siteAllowed(s.SiteName) {
+			u.batchSiteDPI(r, s)
+		} else {
+			r.dropped("sites", 1)
+		}
 	}
 
-	for _, s := range m.IDSList {
-		u.batchIDS(r, s)
+	appTotal := make(totalsDPImap)
+	catTotal := make(totalsDPImap)
+
+	filters := u.clientMACFilters(m.Clients)
+
+	// The filters are checked in the same order as the clients', for the same reasons.
+	for _, s := range m.ClientsDPI {
+		switch reason := u.dpiClientFilter(filters, s.MAC); {
+		case !u.siteAllowed(s.SiteName):
+			r.dropped("sites", 1)
+		case reason != "":
+			r.dropped(reason, 1)
+		case !u.clientSampled(s.MAC):
+			r.dropped("client_sample_rate", 1)
+		default:
+			u.batchClientDPI(r, s, appTotal, catTotal)
+		}
 	}
 
-	u.loopDevicePoints(r)
+	reportClientDPItotals(r, appTotal, catTotal)
 }
 
-func (u *InfluxUnifi) loopDevicePoints(r report) {
+// loopDevicePoints batches every wanted device, and counts the devices in allowed sites in the site rollups.
+func (u *InfluxUnifi) loopDevicePoints(r report, sites rollups) {
 	m := r.metrics()
 	if m.Devices == nil {
 		m.Devices = &unifi.Devices{}
@@ -245,19 +1282,43 @@ func (u *InfluxUnifi) loopDevicePoints(r report) {
 	}
 
 	for _, s := range m.UAPs {
-		u.batchUAP(r, s)
+		if u.siteAllowed(s.SiteName) {
+			sites.uap(s)
+		}
+
+		if u.deviceWanted(r, s.SiteName, s.Mac) {
+			u.batchUAP(r, s)
+		}
 	}
 
 	for _, s := range m.USGs {
-		u.batchUSG(r, s)
+		if u.siteAllowed(s.SiteName) {
+			sites.usg(s)
+		}
+
+		if u.deviceWanted(r, s.SiteName, s.Mac) {
+			u.batchUSG(r, s)
+		}
 	}
 
 	for _, s := range m.USWs {
-		u.batchUSW(r, s)
+		if u.siteAllowed(s.SiteName) {
+			sites.usw(s)
+		}
+
+		if u.deviceWanted(r, s.SiteName, s.Mac) {
+			u.batchUSW(r, s)
+		}
 	}
 
 	for _, s := range m.UDMs {
-		u.batchUDM(r, s)
+		if u.siteAllowed(s.SiteName) {
+			sites.udm(s)
+		}
+
+		if u.deviceWanted(r, s.SiteName, s.Mac) {
+			u.batchUDM(r, s)
+		}
 	}
 }
 
@@ -266,9 +1327,14 @@ func (u *InfluxUnifi) LogInfluxReport(r *Report) {
 	m := r.Metrics
 	idsMsg := fmt.Sprintf("IDS Events: %d, ", len(m.IDSList))
 
-	u.Collector.Logf("UniFi Metrics Recorded. Sites: %d, Clients: %d, "+
-		"UAP: %d, USG/UDM: %d, USW: %d, %sPoints: %d, Fields: %d, Errs: %d, Elapsed: %v",
+	droppedMsg := ""
+	if len(r.Dropped) > 0 {
+		droppedMsg = ", Dropped: " + formatDropped(r.Dropped)
+	}
+
+	u.Logf("UniFi Metrics Recorded. Sites: %d, Clients: %d, "+
+		"UAP: %d, USG/UDM: %d, USW: %d, %sPoints: %d, Fields: %d, Errs: %d, Elapsed: %v%s",
 		len(m.Sites), len(m.Clients), len(m.UAPs),
 		len(m.UDMs)+len(m.USGs), len(m.USWs), idsMsg, r.Total,
-		r.Fields, len(r.Errors), r.Elapsed.Round(time.Millisecond))
+		r.Fields, len(r.Errors), r.Elapsed.Round(time.Millisecond), droppedMsg)
 }
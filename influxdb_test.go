@@ -0,0 +1,273 @@
+package influxunifi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+	"golift.io/cnfg"
+)
+
+func testBatch(t *testing.T) influx.BatchPoints {
+	t.Helper()
+
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{Database: "unifi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bp
+}
+
+func TestWriteRetryDelayCapped(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{WriteRetries: 5, RetryDelay: cnfg.Duration{Duration: 10 * time.Millisecond}})
+	u.Interval = cnfg.Duration{Duration: 15 * time.Millisecond}
+	c := &failClient{}
+
+	// Without the cap the retries wait 10+20+40+80+160ms.
+	start := time.Now()
+	if err := u.write(context.Background(), c, testBatch(t)); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("retries took %v, the delay should be capped at the interval", elapsed)
+	}
+
+	if c.attempts != 6 {
+		t.Errorf("attempts = %d, want 6", c.attempts)
+	}
+}
+
+func TestWriteRetryCanceled(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{WriteRetries: 5, RetryDelay: cnfg.Duration{Duration: time.Hour}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := u.write(ctx, &failClient{}, testBatch(t)); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled retries took %v", elapsed)
+	}
+}
+
+// blockWriter blocks every write until the context is done.
+type blockWriter struct{}
+
+func (blockWriter) WritePoints(ctx context.Context, _ influx.BatchPoints) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPollIntervalCanceled(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{ReconnectAfter: 100})
+	u.Writer = blockWriter{}
+	u.Collector = &testCollector{metrics: &poller.Metrics{TS: testTime}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	u.pollInterval(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("a canceled interval took %v to return", elapsed)
+	}
+
+	if u.failures != 0 {
+		t.Errorf("a canceled interval counted as %d write failures", u.failures)
+	}
+}
+
+func TestPollIntervalDeadline(t *testing.T) {
+	u, _, l := testUnifi(t, &Config{IntervalDeadline: cnfg.Duration{Duration: 20 * time.Millisecond}})
+	u.Writer = blockWriter{}
+	u.Collector = &testCollector{metrics: &poller.Metrics{TS: testTime}}
+
+	start := time.Now()
+
+	if _, err := u.poll(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("poll error = %v, want the deadline exceeded", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("a write past interval_deadline took %v to return", elapsed)
+	}
+
+	if l.count("abandoned write") != 1 {
+		t.Errorf("the abandoned write was not logged")
+	}
+}
+
+func TestPollControllerStopTimeout(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{FlushOnStop: true, StopTimeout: cnfg.Duration{Duration: 20 * time.Millisecond}})
+	u.Writer = blockWriter{}
+	u.Collector = &testCollector{metrics: &poller.Metrics{TS: testTime}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		u.PollControllerContext(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the final flush was not bounded by stop_timeout")
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	u, _, l := testUnifi(t, &Config{Precision: "m"})
+	if u.Precision != "s" || l.count("Invalid InfluxDB precision") != 1 {
+		t.Errorf("invalid precision: got %q, want the s default and a logged error", u.Precision)
+	}
+
+	u, w, _ := testUnifi(t, &Config{Precision: "ms"})
+	mustReport(t, u, &poller.Metrics{TS: testTime})
+
+	if len(w.batches) == 0 {
+		t.Fatal("nothing was written")
+	}
+
+	for _, bp := range w.batches {
+		if bp.Precision() != "ms" {
+			t.Errorf("batch precision = %q, want ms", bp.Precision())
+		}
+	}
+}
+
+func TestDisableToggles(t *testing.T) {
+	all := []string{"subsystems", "sitedpi", "clientdpi", "clients", "intrusion_detect", "usw"}
+
+	for name, test := range map[string]struct {
+		c    *Config
+		gone []string
+	}{
+		"none":     {c: &Config{}},
+		"sites":    {c: &Config{DisableSites: true}, gone: []string{"subsystems"}},
+		"dpi":      {c: &Config{DisableDPI: true}, gone: []string{"sitedpi", "clientdpi"}},
+		"clients":  {c: &Config{DisableClients: true}, gone: []string{"clients"}},
+		"ids":      {c: &Config{DisableIDS: true}, gone: []string{"intrusion_detect"}},
+		"combined": {c: &Config{DisableSites: true, DisableIDS: true}, gone: []string{"subsystems", "intrusion_detect"}},
+	} {
+		u, w, _ := testUnifi(t, test.c)
+		mustReport(t, u, testMetrics(t))
+
+		for _, table := range all {
+			if written := len(w.points(table)) > 0; written == stringInSlice(table, test.gone) {
+				t.Errorf("%s: %s written = %v", name, table, written)
+			}
+		}
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	for _, rp := range []string{"", "two_weeks"} {
+		u, w, _ := testUnifi(t, &Config{RetentionPolicy: rp})
+		mustReport(t, u, testMetrics(t))
+
+		if len(w.batches) == 0 {
+			t.Fatal("nothing was written")
+		}
+
+		for _, bp := range w.batches {
+			if bp.RetentionPolicy() != rp {
+				t.Errorf("batch retention policy = %q, want %q", bp.RetentionPolicy(), rp)
+			}
+		}
+	}
+}
+
+// discardWriter drops every batch, so benchmarks do not keep the points around.
+type discardWriter struct{}
+
+func (discardWriter) WritePoints(context.Context, influx.BatchPoints) error { return nil }
+
+func BenchmarkReportMetrics(b *testing.B) {
+	const clients = 50000
+
+	m := testMetrics(b)
+	base := *m.Clients[0]
+	m.Clients = make(unifi.Clients, clients)
+
+	for i := range m.Clients {
+		c := base
+		c.Mac = fmt.Sprintf("02:00:00:%02x:%02x:%02x", i>>16&0xff, i>>8&0xff, i&0xff)
+		c.RxBytes = int64(i)
+		m.Clients[i] = &c
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			u, _, _ := testUnifi(b, &Config{Workers: workers})
+			u.Writer = discardWriter{}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := u.ReportMetrics(m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTimestampLocalOncePerReport(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{TimestampSource: timestampLocal, Precision: "ns"})
+	r := mustReport(t, u, testMetrics(t))
+
+	for _, p := range w.points("") {
+		if p.Name() != "influxunifi" && !p.Time().Equal(r.Start) {
+			t.Errorf("%s time = %v, want the report start %v", p.Name(), p.Time(), r.Start)
+		}
+	}
+}
+
+func TestOnReportEveryInterval(t *testing.T) {
+	u, _, _ := testUnifi(t, nil)
+	u.Collector = &testCollector{metrics: testMetrics(t)}
+
+	var calls []error
+
+	// Flush takes the poll lock, so this deadlocks if OnReport runs while it's held.
+	u.OnReport = func(r *Report, err error) {
+		calls = append(calls, err)
+
+		if _, fErr := u.Flush(); fErr != nil {
+			t.Errorf("Flush from OnReport: %v", fErr)
+		}
+	}
+
+	u.pollInterval(context.Background())
+
+	u.skipNext = true
+	u.pollInterval(context.Background())
+
+	u.OnReport = func(r *Report, err error) { calls = append(calls, err) }
+	u.Collector = &testCollector{err: errTestWrite}
+	u.pollInterval(context.Background())
+
+	if len(calls) != 3 {
+		t.Fatalf("OnReport called %d times for 3 intervals", len(calls))
+	}
+
+	if calls[0] != nil || !errors.Is(calls[1], errIntervalSkipped) || !errors.Is(calls[2], errTestWrite) {
+		t.Errorf("OnReport errors = %v, want nil, skipped and the fetch error", calls)
+	}
+}
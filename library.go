@@ -0,0 +1,60 @@
+package influxunifi
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/unifi-poller/poller"
+)
+
+// errNoCollector is returned when metrics are requested from an InfluxUnifi built with New().
+var errNoCollector = errors.New("no poller collector; pass metrics to ReportMetrics")
+
+// New returns an InfluxUnifi with a ready InfluxDB client, for use without the poller.
+// Collect your own metrics and pass them to ReportMetrics. Messages are logged with
+// the standard log package; replace Collector to send them somewhere else.
+func New(config *Config) (*InfluxUnifi, error) {
+	return NewWithWriter(config, nil)
+}
+
+// NewWithWriter is the same as New, but points are sent to a Writer instead of
+// InfluxDB. No InfluxDB client is created, so InfluxDB is not pinged and create_db
+// and create_rp do nothing. Mirrors, output_file and dry_run still work.
+func NewWithWriter(config *Config, w Writer) (*InfluxUnifi, error) {
+	if config == nil {
+		return nil, errors.New("InfluxDB config missing")
+	}
+
+	u := &InfluxUnifi{
+		Collector: &logCollector{},
+		Writer:    w,
+		InfluxDB:  &InfluxDB{Config: config},
+		reload:    make(chan struct{}, 1),
+	}
+	if err := u.setup(); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// logCollector satisfies poller.Collect for library use. It only provides logging.
+type logCollector struct{}
+
+func (l *logCollector) Metrics() (*poller.Metrics, bool, error) {
+	return nil, false, errNoCollector
+}
+
+func (l *logCollector) MetricsFrom(*poller.Filter) (*poller.Metrics, bool, error) {
+	return nil, false, errNoCollector
+}
+
+func (l *logCollector) Logf(m string, v ...interface{}) {
+	log.Printf("[INFO] "+m, v...)
+}
+
+func (l *logCollector) LogErrorf(m string, v ...interface{}) {
+	log.Printf("[ERROR] "+m, v...)
+}
+
+func (l *logCollector) LogDebugf(m string, v ...interface{}) {}
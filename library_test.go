@@ -0,0 +1,35 @@
+package influxunifi
+
+import "testing"
+
+func TestNewWithWriter(t *testing.T) {
+	// Nothing listens here. A ping or create_db would log an error.
+	c := &Config{URL: "http://127.0.0.1:1", CreateDB: true}
+	w := &testWriter{}
+
+	u, err := NewWithWriter(c, w)
+	if err != nil {
+		t.Fatalf("NewWithWriter: %v", err)
+	}
+
+	l := &testLogger{}
+	u.Logger = l
+
+	if u.influx != nil {
+		t.Error("an InfluxDB client was created for a Writer")
+	}
+
+	mustReport(t, u, testMetrics(t))
+
+	if len(w.points("")) == 0 {
+		t.Error("no points were sent to the Writer")
+	}
+
+	for i := 0; i < u.ReconnectAfter; i++ {
+		u.writeFailed()
+	}
+
+	if u.influx != nil || l.count("Reconnected") != 0 {
+		t.Error("failed writes to a Writer reconnected to InfluxDB")
+	}
+}
@@ -0,0 +1,57 @@
+package influxunifi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// writeLimiter spaces out writes to protect a shared InfluxDB server.
+// One limiter is shared by every chunk, retry and mirror write. If the limit
+// is too low for the number of chunks written each interval, writes back up and
+// polls run late. skip_overrun then skips intervals to catch up, and with
+// interval_deadline, writes still waiting at the deadline are abandoned.
+type writeLimiter struct {
+	sync.Mutex
+	every time.Duration
+	next  time.Time
+}
+
+// newWriteLimiter returns a limiter allowing perSecond writes, or nil for no limit.
+func newWriteLimiter(perSecond float64) *writeLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &writeLimiter{every: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next write is allowed, or the context is done.
+// A nil limiter never waits.
+func (l *writeLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.Lock()
+	now := time.Now()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.every)
+	l.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
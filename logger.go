@@ -0,0 +1,50 @@
+package influxunifi
+
+import "strings"
+
+// Values for the log_level setting. Without a log_level, every message is passed
+// to the logger, and the poller's own quiet and debug settings decide what prints.
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"  // Hides debug messages.
+	logLevelError = "error" // Hides debug and info messages, like the per-interval report.
+)
+
+// logger returns the Logger if one was provided, otherwise the Collector.
+// Set InfluxUnifi.Logger to send this plugin's messages to a structured logger.
+func (u *InfluxUnifi) logger() Logger {
+	if u.Logger != nil {
+		return u.Logger
+	}
+
+	return u.Collector
+}
+
+// Logf logs an info message, unless log_level is error.
+func (u *InfluxUnifi) Logf(m string, v ...interface{}) {
+	if u.LogLevel != logLevelError {
+		u.logger().Logf(m, v...)
+	}
+}
+
+// LogErrorf logs an error message.
+func (u *InfluxUnifi) LogErrorf(m string, v ...interface{}) {
+	u.logger().LogErrorf(m, v...)
+}
+
+// LogDebugf logs a debug message, unless log_level is info or error.
+func (u *InfluxUnifi) LogDebugf(m string, v ...interface{}) {
+	if u.LogLevel != logLevelInfo && u.LogLevel != logLevelError {
+		u.logger().LogDebugf(m, v...)
+	}
+}
+
+// checkLogLevel validates log_level.
+func (u *InfluxUnifi) checkLogLevel() {
+	switch u.LogLevel = strings.ToLower(u.LogLevel); u.LogLevel {
+	case "", logLevelDebug, logLevelInfo, logLevelError:
+	default:
+		u.LogErrorf("Invalid InfluxDB log_level '%s', logging everything", u.LogLevel)
+		u.LogLevel = ""
+	}
+}
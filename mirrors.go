@@ -0,0 +1,83 @@
+package influxunifi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+)
+
+// mirror is an additional InfluxDB endpoint that receives a copy of every batch.
+type mirror struct {
+	url    string
+	client influx.Client
+}
+
+// setupMirrors creates a client for each extra server in the config. Only the
+// connection settings of a server are used: url, user, pass, org, token, protocol,
+// payload_size, compress, proxy_url, headers, verify_ssl, tls_ca, tls_cert and tls_key.
+// Mirrors receive the same databases (or buckets) as the primary server. An unset
+// user or pass gets the default, and an invalid protocol is logged and replaced with
+// http, like the primary's. Every mirror has its own verify_ssl; it is not inherited
+// from the primary, so a mirror with a self-signed certificate can turn it off.
+func (u *InfluxUnifi) setupMirrors() error {
+	for i, s := range u.Servers {
+		if s == nil || s.URL == "" {
+			return errors.Errorf("InfluxDB server %d is missing a url", i+1)
+		}
+
+		if strings.HasPrefix(s.User, "file://") {
+			s.User = u.getFromFile("Username", strings.TrimPrefix(s.User, "file://"))
+		}
+
+		if strings.HasPrefix(s.Pass, "file://") {
+			s.Pass = u.getFromFile("Password", strings.TrimPrefix(s.Pass, "file://"))
+		}
+
+		if strings.HasPrefix(s.Token, "file://") {
+			s.Token = u.getFromFile("Token", strings.TrimPrefix(s.Token, "file://"))
+		}
+
+		if s.Token != "" && s.Org == "" {
+			return errors.Errorf("InfluxDB server %s: org must be set when a token is provided", redactURL(s.URL))
+		}
+
+		u.setMirrorDefaults(fmt.Sprintf("servers[%d]", i), s)
+
+		client, err := s.newClient(u.Logf)
+		if err != nil {
+			return errors.Wrapf(err, "InfluxDB server %s", redactURL(s.URL))
+		}
+
+		u.mirrors = append(u.mirrors, &mirror{url: redactURL(s.URL), client: client})
+	}
+
+	return nil
+}
+
+// setMirrorDefaults applies defaults to a mirror's settings.
+// name identifies the mirror in log messages, like servers[0].
+func (u *InfluxUnifi) setMirrorDefaults(name string, s *Config) {
+	if s.User == "" {
+		s.User = defaultInfluxUser
+	}
+
+	if s.Pass == "" {
+		s.Pass = defaultInfluxUser
+	}
+
+	s.Protocol = u.checkProtocol(name+".protocol", s.Protocol)
+}
+
+// writeMirror writes batches to a mirror. Failures are added to the report
+// and never stop the remaining batches from being written.
+func (u *InfluxUnifi) writeMirror(ctx context.Context, r *Report, m *mirror, batches map[string]influx.BatchPoints) {
+	for _, bp := range batches {
+		err := u.writeChunks(ctx, m.client, bp)
+		r.error(errors.Wrapf(err, "influxdb.Write(points) server: %s, db: %s", m.url, bp.Database()))
+		r.written(m.url, len(bp.Points()), err)
+		countWrite(m.url, len(bp.Points()), err)
+	}
+}
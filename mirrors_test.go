@@ -0,0 +1,125 @@
+package influxunifi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+)
+
+func TestMirrorDefaults(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{VerifySSL: true})
+	s := &Config{URL: "https://mirror:8086", Protocol: "HTTP"}
+
+	u.setMirrorDefaults("servers[0]", s)
+
+	if s.User != defaultInfluxUser || s.Pass != defaultInfluxUser {
+		t.Errorf("mirror credentials = %q/%q, want the defaults", s.User, s.Pass)
+	}
+
+	if s.Protocol != protocolHTTP {
+		t.Errorf("mirror protocol = %q, want %q", s.Protocol, protocolHTTP)
+	}
+
+	if s.VerifySSL {
+		t.Error("mirror verify_ssl was turned on by the primary's")
+	}
+}
+
+// dbWriter fails every write to one database.
+type dbWriter struct {
+	testWriter
+	fail string
+}
+
+func (w *dbWriter) WritePoints(ctx context.Context, bp influx.BatchPoints) error {
+	if bp.Database() == w.fail {
+		return errTestWrite
+	}
+
+	return w.testWriter.WritePoints(ctx, bp)
+}
+
+func TestWriteContinuesAfterFailure(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{MeasurementDB: map[string]string{"usw": "switches"}})
+	w := &dbWriter{fail: u.DB}
+	u.Writer = w
+
+	s := benchUSW()
+	r, err := u.ReportMetrics(&poller.Metrics{TS: testTime, Devices: &unifi.Devices{USWs: []*unifi.USW{s}}})
+
+	if err == nil {
+		t.Fatal("expected the failed write to be returned")
+	}
+
+	if r == nil {
+		t.Fatal("the report was not returned with the write error")
+	}
+
+	if len(w.points("usw")) != 1 {
+		t.Error("the switches database was not written after the default database failed")
+	}
+
+	if r.Written["writer"] == 0 || r.Failed["writer"] == 0 {
+		t.Errorf("written = %v, failed = %v; want both counted", r.Written, r.Failed)
+	}
+}
+
+// signalClient is an InfluxDB client that closes written on the first write.
+type signalClient struct {
+	influx.Client
+	once    sync.Once
+	written chan struct{}
+}
+
+func (c *signalClient) Write(influx.BatchPoints) error {
+	c.once.Do(func() { close(c.written) })
+	return nil
+}
+
+// waitWriter only finishes a write after the mirror is written, or fails after a second.
+type waitWriter struct {
+	mirrored chan struct{}
+}
+
+func (w *waitWriter) WritePoints(context.Context, influx.BatchPoints) error {
+	select {
+	case <-w.mirrored:
+		return nil
+	case <-time.After(time.Second):
+		return errTestWrite
+	}
+}
+
+func TestMirrorsWrittenConcurrently(t *testing.T) {
+	u, _, _ := testUnifi(t, nil)
+	c := &signalClient{written: make(chan struct{})}
+	u.Writer = &waitWriter{mirrored: c.written}
+	u.mirrors = []*mirror{{url: "http://mirror:8086", client: c}}
+
+	// A slow primary write must not hold up the mirror.
+	r := mustReport(t, u, testMetrics(t))
+
+	if r.Written["writer"] == 0 || r.Written["http://mirror:8086"] == 0 {
+		t.Errorf("written = %v, want the primary and the mirror", r.Written)
+	}
+}
+
+func TestMirrorInvalidProtocol(t *testing.T) {
+	u, _, l := testUnifi(t, nil)
+	s := &Config{URL: "https://mirror:8086", Protocol: "tcp"}
+
+	u.setMirrorDefaults("servers[0]", s)
+
+	if s.Protocol != protocolHTTP {
+		t.Errorf("mirror protocol = %q, want %q", s.Protocol, protocolHTTP)
+	}
+
+	if l.count("Invalid InfluxDB servers[0].protocol 'tcp'") != 1 {
+		t.Errorf("expected the invalid mirror protocol to be logged, errors: %v", l.errors)
+	}
+}
@@ -0,0 +1,48 @@
+package influxunifi
+
+import (
+	"context"
+	"net/url"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Writer receives every batch of points after processing, one batch per database.
+// InfluxDB is the default. Use NewWithWriter, or set InfluxUnifi.Writer before Run,
+// to send the same points to another backend, like stdout or a TSDB that accepts
+// line protocol. Dry runs, output_file and the mirror servers work the same with any Writer.
+type Writer interface {
+	WritePoints(ctx context.Context, bp influx.BatchPoints) error
+}
+
+// influxWriter is the default Writer. It writes to the primary InfluxDB server.
+type influxWriter struct {
+	u *InfluxUnifi
+}
+
+func (w *influxWriter) WritePoints(ctx context.Context, bp influx.BatchPoints) error {
+	return w.u.writeChunks(ctx, w.u.influx, bp)
+}
+
+// output returns the Writer points are sent to, and its name for the report.
+func (u *InfluxUnifi) output() (Writer, string) {
+	if u.Writer != nil {
+		return u.Writer, "writer"
+	}
+
+	return &influxWriter{u: u}, redactURL(u.URL)
+}
+
+// redactURL removes credentials and the query string from a server URL, so it
+// can be used in reports, errors and Prometheus labels.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+
+	return u.String()
+}
@@ -0,0 +1,132 @@
+package influxunifi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeStaleIntervals is the default stale_after, in intervals.
+const probeStaleIntervals = 3
+
+// probeTimeout bounds reading a probe request, writing the response, and shutting down.
+const probeTimeout = 10 * time.Second
+
+// probeState is the poll loop status served by the probe handler.
+type probeState struct {
+	sync.Mutex
+	lastCheck time.Time
+	lastWrite time.Time
+	lastErr   error
+	stale     time.Duration // A copy of stale_after, so the handler never reads the config.
+}
+
+// probeStatus is the JSON body served by the probe handler.
+type probeStatus struct {
+	Healthy    bool      `json:"healthy"`
+	LastCheck  time.Time `json:"last_check"`
+	LastWrite  time.Time `json:"last_write"`
+	LastError  string    `json:"last_error,omitempty"`
+	StaleAfter string    `json:"stale_after"`
+}
+
+// probed records the result of a poll or buffer flush for the probe handler.
+// Only a report with a successful write counts as a write. Points that were only
+// buffered, or logged by dry_run, were not written, so flush_interval should be
+// shorter than stale_after.
+func (u *InfluxUnifi) probed(r *Report, err error) {
+	u.probe.Lock()
+	defer u.probe.Unlock()
+
+	u.probe.lastCheck = time.Now()
+	u.probe.lastErr = err
+	u.probe.stale = u.StaleAfter.Duration
+
+	if err == nil && r.wrote() {
+		u.probe.lastWrite = u.probe.lastCheck
+	}
+}
+
+// ProbeHandler returns an HTTP handler for liveness and readiness probes.
+// It responds 200 while the last successful write is newer than stale_after,
+// and 503 otherwise, with the last poll time, write time and error as JSON.
+// Before the first write the poller has stale_after to succeed, from startup.
+func (u *InfluxUnifi) ProbeHandler() http.Handler {
+	started := time.Now()
+
+	u.probe.Lock()
+	u.probe.stale = u.StaleAfter.Duration
+	u.probe.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		u.probe.Lock()
+		stale := u.probe.stale
+		status := probeStatus{
+			LastCheck:  u.probe.lastCheck,
+			LastWrite:  u.probe.lastWrite,
+			StaleAfter: stale.String(),
+		}
+
+		if u.probe.lastErr != nil {
+			status.LastError = u.probe.lastErr.Error()
+		}
+		u.probe.Unlock()
+
+		since := status.LastWrite
+		if since.IsZero() {
+			since = started
+		}
+
+		status.Healthy = time.Since(since) <= stale
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(w).Encode(status)
+	})
+}
+
+// startProbe serves ProbeHandler on probe_listen at /health, and the Prometheus
+// default registry at /metrics, if probe_listen is set. Call the returned function
+// to shut the listener down.
+func (u *InfluxUnifi) startProbe() func() {
+	if u.ProbeListen == "" {
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/health", u.ProbeHandler())
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              u.ProbeListen,
+		Handler:           mux,
+		ReadHeaderTimeout: probeTimeout,
+		ReadTimeout:       probeTimeout,
+		WriteTimeout:      probeTimeout,
+		IdleTimeout:       probeTimeout,
+	}
+
+	go func() {
+		u.Logf("InfluxDB probe listening on http://%s/health and /metrics", u.ProbeListen)
+
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			u.LogErrorf("InfluxDB probe listener stopped: %v", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+
+		_ = server.Shutdown(ctx)
+	}
+}
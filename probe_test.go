@@ -0,0 +1,91 @@
+package influxunifi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golift.io/cnfg"
+)
+
+// lastWrite returns the last write time recorded for the probe handler.
+func lastWrite(u *InfluxUnifi) time.Time {
+	u.probe.Lock()
+	defer u.probe.Unlock()
+
+	return u.probe.lastWrite
+}
+
+func TestProbeRealWrites(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{FlushInterval: cnfg.Duration{Duration: time.Minute}})
+	u.Collector = &testCollector{metrics: testMetrics(t)}
+
+	if u.pollInterval(context.Background()); !lastWrite(u).IsZero() {
+		t.Error("buffered points counted as a write")
+	}
+
+	if u.flushBuffer(context.Background()); lastWrite(u).IsZero() {
+		t.Error("the buffer flush was not recorded as a write")
+	}
+
+	u, _, _ = testUnifi(t, &Config{DryRun: true})
+	u.Collector = &testCollector{metrics: testMetrics(t)}
+
+	if u.pollInterval(context.Background()); !lastWrite(u).IsZero() {
+		t.Error("a dry run counted as a write")
+	}
+}
+
+// freeAddr returns a local address with a port nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("finding a free port: %v", err)
+	}
+
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// probeUp returns true if the probe listener answers on addr within a second.
+func probeUp(addr string) bool {
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if resp, err := client.Get("http://" + addr + "/health"); err == nil {
+			resp.Body.Close()
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestProbeShutdown(t *testing.T) {
+	u, _, _ := testUnifi(t, &Config{ProbeListen: freeAddr(t)})
+	u.Collector = &testCollector{metrics: testMetrics(t)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		u.PollControllerContext(ctx)
+		close(done)
+	}()
+
+	if !probeUp(u.ProbeListen) {
+		t.Fatal("the probe listener did not start")
+	}
+
+	cancel()
+	<-done
+
+	if _, err := net.DialTimeout("tcp", u.ProbeListen, 100*time.Millisecond); err == nil {
+		t.Error("the probe listener is still running after the poller stopped")
+	}
+}
@@ -0,0 +1,60 @@
+package influxunifi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// These are registered with the default Prometheus registry, so any exporter
+// serving that registry includes them. The probe listener serves them at /metrics.
+// The server label is the InfluxDB URL without credentials or query string.
+var (
+	promPointsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint: gochecknoglobals
+		Namespace: "influxunifi",
+		Name:      "points_written_total",
+		Help:      "Points written to InfluxDB.",
+	}, []string{"server"})
+	promWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint: gochecknoglobals
+		Namespace: "influxunifi",
+		Name:      "write_errors_total",
+		Help:      "Failed InfluxDB writes, after retries.",
+	}, []string{"server"})
+	promReportDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{ // nolint: gochecknoglobals
+		Namespace: "influxunifi",
+		Name:      "report_duration_seconds",
+		Help:      "Time spent batching and writing the points for one interval.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+	promOverruns = prometheus.NewCounter(prometheus.CounterOpts{ // nolint: gochecknoglobals
+		Namespace: "influxunifi",
+		Name:      "interval_overruns_total",
+		Help:      "Intervals where fetching and writing took longer than the interval.",
+	})
+)
+
+func init() { // nolint: gochecknoinits
+	prometheus.MustRegister(promPointsWritten, promWriteErrors, promReportDuration, promOverruns)
+}
+
+// countWrite updates the Prometheus write counters for an InfluxDB server.
+func countWrite(server string, points int, err error) {
+	if err != nil {
+		promWriteErrors.WithLabelValues(server).Inc()
+		return
+	}
+
+	promPointsWritten.WithLabelValues(server).Add(float64(points))
+}
+
+// observeReport records how long an interval took, labeled ok or error.
+func observeReport(r *Report, err error) {
+	if r == nil {
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	promReportDuration.WithLabelValues(result).Observe(r.Elapsed.Seconds())
+}
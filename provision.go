@@ -0,0 +1,121 @@
+package influxunifi
+
+import (
+	"fmt"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// createDatabases creates every database points are written to, if it is missing.
+// This runs once at startup with create_db enabled. Failures, like a user without
+// admin rights, are logged and writes are attempted anyway.
+func (u *InfluxUnifi) createDatabases() {
+	if !u.CreateDB || u.influx == nil {
+		return
+	}
+
+	if u.Token != "" {
+		u.Logf("InfluxDB create_db ignored: InfluxDB 2.x buckets must be created in InfluxDB")
+		return
+	}
+
+	existing, err := u.queryNames("SHOW DATABASES", "")
+	if err != nil {
+		u.LogErrorf("Listing InfluxDB databases (create_db): %v", err)
+		return
+	}
+
+	for _, db := range u.databases() {
+		if existing[db] {
+			u.Logf("InfluxDB database already exists: %s", db)
+			continue
+		}
+
+		if err := u.query(fmt.Sprintf("CREATE DATABASE %q", db), ""); err != nil {
+			u.LogErrorf("Creating InfluxDB database %s: %v", db, err)
+			continue
+		}
+
+		u.Logf("Created InfluxDB database: %s", db)
+	}
+}
+
+// createRetentionPolicies creates the retention_policy, as the default, in every
+// database points are written to, if it is missing. Existing policies are never
+// changed. This runs once at startup with create_rp enabled, and errors are only logged.
+func (u *InfluxUnifi) createRetentionPolicies() {
+	if !u.CreateRP || u.influx == nil {
+		return
+	}
+
+	if u.Token != "" || u.RetentionPolicy == "" {
+		u.Logf("InfluxDB create_rp ignored: it needs retention_policy set, and does not work with InfluxDB 2.x")
+		return
+	}
+
+	duration := "INF"
+	if u.RPDuration.Duration > 0 {
+		duration = fmt.Sprintf("%ds", int64(u.RPDuration.Seconds()))
+	}
+
+	replication := u.RPReplication
+	if replication < 1 {
+		replication = 1
+	}
+
+	for _, db := range u.databases() {
+		existing, err := u.queryNames(fmt.Sprintf("SHOW RETENTION POLICIES ON %q", db), db)
+		if err != nil {
+			u.LogErrorf("Listing InfluxDB retention policies on %s (create_rp): %v", db, err)
+			continue
+		}
+
+		if existing[u.RetentionPolicy] {
+			u.Logf("InfluxDB retention policy already exists: %s on %s", u.RetentionPolicy, db)
+			continue
+		}
+
+		err = u.query(fmt.Sprintf("CREATE RETENTION POLICY %q ON %q DURATION %s REPLICATION %d DEFAULT",
+			u.RetentionPolicy, db, duration, replication), db)
+		if err != nil {
+			u.LogErrorf("Creating InfluxDB retention policy %s on %s: %v", u.RetentionPolicy, db, err)
+			continue
+		}
+
+		u.Logf("Created InfluxDB retention policy: %s on %s, duration: %s", u.RetentionPolicy, db, duration)
+	}
+}
+
+// query runs an InfluxQL statement that returns no data.
+func (u *InfluxUnifi) query(command, db string) error {
+	resp, err := u.influx.Query(influx.NewQuery(command, db, ""))
+	if err != nil {
+		return err
+	}
+
+	return resp.Error()
+}
+
+// queryNames runs a SHOW statement and returns the values in its first column.
+func (u *InfluxUnifi) queryNames(command, db string) (map[string]bool, error) {
+	resp, err := u.influx.Query(influx.NewQuery(command, db, ""))
+	if err != nil {
+		return nil, err
+	} else if err = resp.Error(); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+
+	for _, result := range resp.Results {
+		for _, series := range result.Series {
+			for _, row := range series.Values {
+				if len(row) > 0 {
+					names[fmt.Sprint(row[0])] = true
+				}
+			}
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,48 @@
+package influxunifi
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golift.io/cnfg"
+)
+
+// TestReportMetricsConcurrent runs ReportMetrics from several goroutines, with
+// several collect workers and the shared counter, session, dedup and buffer state
+// in use. It finds nothing on its own; run it with go test -race.
+func TestReportMetricsConcurrent(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{
+		Workers:          8,
+		Dedup:            true,
+		ComputeRates:     true,
+		CounterResetMode: counterResetCarry,
+		SiteRollup:       true,
+		FlushInterval:    cnfg.Duration{Duration: time.Minute},
+		BufferSize:       10,
+	})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		metrics := testMetrics(t) // Each goroutine has its own, like each poll does.
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				if _, err := u.ReportMetrics(metrics); err != nil {
+					t.Errorf("ReportMetrics: %v", err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(w.points("")) == 0 {
+		t.Error("nothing was written")
+	}
+}
@@ -0,0 +1,85 @@
+package influxunifi
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// UpdateConfig replaces the running configuration, for use with the poller's reload signal.
+// The new config is validated and its InfluxDB client is built first; if that fails
+// the old config stays in place. An interval that is being polled or flushed finishes
+// with the old client before the swap. PollController picks up interval and
+// flush_interval changes right away. Counter, session and flap state is kept.
+// InfluxDB is only pinged, and create_db and create_rp only run, when the server,
+// database or retention policy settings change. probe_listen needs a restart.
+// Do not call this while calling ReportMetrics yourself.
+func (u *InfluxUnifi) UpdateConfig(c *Config) error {
+	if c == nil {
+		return errors.New("InfluxDB config missing")
+	}
+
+	next := &InfluxUnifi{Collector: u.Collector, Logger: u.Logger, Writer: u.Writer, InfluxDB: &InfluxDB{Config: c}}
+	if err := next.prepare(); err != nil {
+		next.closeClients()
+		return errors.Wrap(err, "InfluxDB config not updated")
+	}
+
+	u.mu.Lock()
+	prevConfig := u.Config
+	u.mu.Unlock()
+
+	if serverChanged(prevConfig, next.Config) {
+		next.provision()
+	}
+
+	if next.ProbeListen != prevConfig.ProbeListen {
+		u.Logf("InfluxDB probe_listen changes take effect after a restart, still using: %s", prevConfig.ProbeListen)
+	}
+
+	u.mu.Lock()
+	prev := &InfluxUnifi{influx: u.influx, mirrors: u.mirrors, geoip: u.geoip}
+
+	u.InfluxDB = next.InfluxDB
+	u.influx = next.influx
+	u.mirrors = next.mirrors
+	u.geoip = next.geoip
+	u.limiter = next.limiter
+	u.file = next.file
+	u.devices = next.devices
+	u.userFile, u.passFile, u.tokenFile = next.userFile, next.passFile, next.tokenFile
+	u.failures = 0
+	u.mu.Unlock()
+
+	prev.closeClients()
+
+	select {
+	case u.reload <- struct{}{}:
+	default: // A reload is already pending.
+	}
+
+	u.Logf("InfluxDB config updated, interval: %v, url: %s", c.Interval, redactURL(c.URL))
+
+	return nil
+}
+
+// closeClients closes the InfluxDB clients and geoip databases opened by prepare.
+func (u *InfluxUnifi) closeClients() {
+	if u.influx != nil {
+		_ = u.influx.Close()
+	}
+
+	for _, m := range u.mirrors {
+		_ = m.client.Close()
+	}
+
+	u.geoip.close()
+}
+
+// serverChanged returns true if the settings used to provision InfluxDB changed.
+func serverChanged(prev, next *Config) bool {
+	return prev.URL != next.URL || prev.DB != next.DB || prev.Token != next.Token ||
+		prev.CreateDB != next.CreateDB || prev.CreateRP != next.CreateRP ||
+		prev.RetentionPolicy != next.RetentionPolicy || prev.RPDuration != next.RPDuration ||
+		prev.RPReplication != next.RPReplication || !reflect.DeepEqual(prev.MeasurementDB, next.MeasurementDB)
+}
@@ -0,0 +1,29 @@
+package influxunifi
+
+import "testing"
+
+func TestUpdateConfigProvision(t *testing.T) {
+	u, _, l := testUnifi(t, &Config{URL: "http://127.0.0.1:1"})
+	u.Writer = nil // Writers have no InfluxDB client to ping.
+
+	// Nothing listens on these ports, so every ping logs an error.
+	if err := u.UpdateConfig(&Config{URL: "http://127.0.0.1:1", DisableIDS: true}); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+
+	if n := l.count("not reachable"); n != 0 {
+		t.Errorf("InfluxDB pinged %d times on a reload that did not change the server", n)
+	}
+
+	if err := u.UpdateConfig(&Config{URL: "http://127.0.0.1:2", ProbeListen: "127.0.0.1:0"}); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+
+	if n := l.count("not reachable"); n != 1 {
+		t.Errorf("InfluxDB pinged %d times after the url changed, want 1", n)
+	}
+
+	if l.count("probe_listen changes take effect after a restart") != 1 {
+		t.Error("expected a log line for the ignored probe_listen change")
+	}
+}
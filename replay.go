@@ -0,0 +1,119 @@
+package influxunifi
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+)
+
+// replayMaxLine is the longest line protocol line Replay accepts.
+const replayMaxLine = 1024 * 1024
+
+// ReplayReport summarizes a Replay.
+type ReplayReport struct {
+	Imported int           // Points written to InfluxDB.
+	Skipped  int           // Blank, comment and malformed lines.
+	Elapsed  time.Duration // Time spent reading and writing the file.
+}
+
+// Replay writes a line protocol file, like one made with output_file, to InfluxDB.
+// Points are sent in batch_size chunks with the usual retries, and routed to
+// databases with measurement_db. Timestamps are read with the configured precision.
+// Malformed lines are skipped and counted. An error is returned if the file
+// cannot be read or a write fails; the report counts the points written so far.
+func (u *InfluxUnifi) Replay(path string) (*ReplayReport, error) {
+	rr := &ReplayReport{}
+	start := time.Now()
+
+	defer func() { rr.Elapsed = time.Since(start) }()
+
+	if u.influx == nil {
+		return rr, errors.New("replay needs an InfluxDB client; dry_run, output_file_only and a Writer do not create one")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return rr, errors.Wrap(err, "opening replay file")
+	}
+	defer file.Close()
+
+	batches := make(map[string]influx.BatchPoints)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), replayMaxLine)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			rr.Skipped++
+			continue
+		}
+
+		points, err := models.ParsePointsWithPrecision([]byte(line), time.Now(), v1Precision(u.Precision))
+		if err != nil || len(points) != 1 {
+			rr.Skipped++
+			continue
+		}
+
+		pt := influx.NewPointFrom(points[0])
+		key := u.measurementBatch(pt.Name())
+
+		if batches[key] == nil {
+			if batches[key], err = u.newReplayBatch(pt.Name()); err != nil {
+				return rr, err
+			}
+		}
+
+		if batches[key].AddPoint(pt); len(batches[key].Points()) < u.BatchSize {
+			continue
+		}
+
+		if err := u.replayBatch(batches[key], rr); err != nil {
+			return rr, err
+		}
+
+		delete(batches, key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return rr, errors.Wrap(err, "reading replay file")
+	}
+
+	for _, bp := range batches {
+		if err := u.replayBatch(bp, rr); err != nil {
+			return rr, err
+		}
+	}
+
+	return rr, nil
+}
+
+// newReplayBatch returns an empty batch for a measurement's database and
+// retention policy, with the configured write settings.
+func (u *InfluxUnifi) newReplayBatch(table string) (influx.BatchPoints, error) {
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{
+		Database:         u.measurementDB(table),
+		Precision:        u.Precision,
+		RetentionPolicy:  u.measurementRP(table),
+		WriteConsistency: u.Consistency,
+	})
+
+	return bp, errors.Wrap(err, "influx.NewBatchPoint")
+}
+
+// replayBatch writes one batch of replayed points and counts them.
+func (u *InfluxUnifi) replayBatch(bp influx.BatchPoints, rr *ReplayReport) error {
+	if err := u.writeChunks(context.Background(), u.influx, bp); err != nil {
+		return errors.Wrapf(err, "influxdb.Write(points) db: %s", bp.Database())
+	}
+
+	rr.Imported += len(bp.Points())
+
+	return nil
+}
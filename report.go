@@ -1,6 +1,9 @@
 package influxunifi
 
 import (
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,11 +17,16 @@ type Report struct {
 	Errors  []error
 	Total   int
 	Fields  int
+	Series  int            // Distinct measurement and tag set combinations.
+	Dropped map[string]int // Everything left out, by reason: the setting that dropped it.
 	Start   time.Time
 	Elapsed time.Duration
+	Written map[string]int // Points written, by InfluxDB URL without credentials.
+	Failed  map[string]int // Points that failed to write, by InfluxDB URL.
 	ch      chan *metric
 	wg      sync.WaitGroup
-	bp      influx.BatchPoints
+	mu      sync.Mutex
+	bp      map[string]influx.BatchPoints
 }
 
 // report is an internal interface that can be mocked and overrridden for tests.
@@ -27,14 +35,20 @@ type report interface {
 	done()
 	send(m *metric)
 	error(err error)
-	batch(m *metric, pt *influx.Point)
+	dropped(reason string, count int)
+	batch(key string, m *metric, pt *influx.Point)
 	metrics() *poller.Metrics
+	start() time.Time
 }
 
 func (r *Report) metrics() *poller.Metrics {
 	return r.Metrics
 }
 
+func (r *Report) start() time.Time {
+	return r.Start
+}
+
 func (r *Report) add() {
 	r.wg.Add(1)
 }
@@ -48,16 +62,94 @@ func (r *Report) send(m *metric) {
 	r.ch <- m
 }
 
-/* The following methods are not thread safe. */
+/* The following methods are called by every collect worker, so they lock. */
 
 func (r *Report) error(err error) {
 	if err != nil {
+		r.mu.Lock()
 		r.Errors = append(r.Errors, err)
+		r.mu.Unlock()
+	}
+}
+
+// dropped counts dropped items by reason.
+func (r *Report) dropped(reason string, count int) {
+	if count == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Dropped == nil {
+		r.Dropped = make(map[string]int)
 	}
+
+	r.Dropped[reason] += count
 }
 
-func (r *Report) batch(m *metric, p *influx.Point) {
+func (r *Report) batch(key string, m *metric, p *influx.Point) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.Total++
 	r.Fields += len(m.Fields)
-	r.bp.AddPoint(p)
+	r.bp[key].AddPoint(p)
+}
+
+// written counts the points written to, or failed to write to, an InfluxDB endpoint.
+func (r *Report) written(url string, points int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Written == nil {
+		r.Written, r.Failed = make(map[string]int), make(map[string]int)
+	}
+
+	if err != nil {
+		r.Failed[url] += points
+	} else {
+		r.Written[url] += points
+	}
+}
+
+// wrote returns true if anything was written: to InfluxDB, the Writer, or a mirror.
+func (r *Report) wrote() bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.Written) > 0
+}
+
+// emptyBatch returns a new batch with the same settings as the one provided.
+func emptyBatch(bp influx.BatchPoints) influx.BatchPoints {
+	// This only fails on a bad precision, and the precision came from the existing batch.
+	out, _ := influx.NewBatchPoints(influx.BatchPointsConfig{
+		Precision:        bp.Precision(),
+		Database:         bp.Database(),
+		RetentionPolicy:  bp.RetentionPolicy(),
+		WriteConsistency: bp.WriteConsistency(),
+	})
+
+	return out
+}
+
+// formatDropped returns dropped counts as reason=count pairs, sorted by reason.
+func formatDropped(dropped map[string]int) string {
+	reasons := make([]string, 0, len(dropped))
+	for reason := range dropped {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Strings(reasons)
+
+	for i, reason := range reasons {
+		reasons[i] = reason + "=" + strconv.Itoa(dropped[reason])
+	}
+
+	return strings.Join(reasons, ", ")
 }
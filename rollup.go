@@ -0,0 +1,142 @@
+package influxunifi
+
+import (
+	"github.com/unifi-poller/unifi"
+)
+
+// siteRollup holds the totals for one site.
+type siteRollup struct {
+	source     string
+	siteName   string
+	clients    int
+	wired      int
+	wireless   int
+	aps        int
+	switches   int
+	gateways   int
+	adopted    int
+	offline    int
+	upgradable int
+	rxBytesR   int64
+	txBytesR   int64
+}
+
+// rollups collects siteRollups by controller and site name.
+type rollups map[string]*siteRollup
+
+func (s rollups) site(source, siteName string) *siteRollup {
+	key := source + "\x00" + siteName
+	if s[key] == nil {
+		s[key] = &siteRollup{source: source, siteName: siteName}
+	}
+
+	return s[key]
+}
+
+// device counts a device of any type. Offline is state 0 (disconnected).
+func (s *siteRollup) device(adopted, upgradable bool, state float64) {
+	if adopted {
+		s.adopted++
+	}
+
+	if upgradable {
+		s.upgradable++
+	}
+
+	if state == 0 {
+		s.offline++
+	}
+}
+
+// newRollups returns the site rollups to fill in while batching, or nil
+// when site_rollup is off, or sites are disabled.
+func (u *InfluxUnifi) newRollups() rollups {
+	if !u.SiteRollup || u.DisableSites {
+		return nil
+	}
+
+	return make(rollups)
+}
+
+// client counts a client in its site's rollup. Throughput is client bytes/sec.
+func (s rollups) client(c *unifi.Client) {
+	if s == nil {
+		return
+	}
+
+	site := s.site(c.SourceName, c.SiteName)
+	site.clients++
+
+	if c.IsWired.Val {
+		site.wired++
+		site.rxBytesR += c.WiredRxBytesR
+		site.txBytesR += c.WiredTxBytesR
+	} else {
+		site.wireless++
+		site.rxBytesR += c.RxBytesR
+		site.txBytesR += c.TxBytesR
+	}
+}
+
+// uap, usg, usw and udm count a device in its site's rollup. UDMs count as gateways.
+func (s rollups) uap(d *unifi.UAP) {
+	if s != nil {
+		site := s.site(d.SourceName, d.SiteName)
+		site.aps++
+		site.device(d.Adopted.Val, d.Upgradable.Val, d.State.Val)
+	}
+}
+
+func (s rollups) usg(d *unifi.USG) {
+	if s != nil {
+		site := s.site(d.SourceName, d.SiteName)
+		site.gateways++
+		site.device(d.Adopted.Val, d.Upgradable.Val, d.State.Val)
+	}
+}
+
+func (s rollups) usw(d *unifi.USW) {
+	if s != nil {
+		site := s.site(d.SourceName, d.SiteName)
+		site.switches++
+		site.device(d.Adopted.Val, d.Upgradable.Val, d.State.Val)
+	}
+}
+
+func (s rollups) udm(d *unifi.UDM) {
+	if s != nil {
+		site := s.site(d.SourceName, d.SiteName)
+		site.gateways++
+		site.device(d.Adopted.Val, false, d.State.Val) // UDMs do not report upgradable.
+	}
+}
+
+// batchSiteRollups writes one site_rollup point per site with client, device and
+// throughput totals, so a site overview does not have to sum every device and client.
+// It's off by default; enable site_rollup. The totals are counted in the client and
+// device loops, so every client and device in an allowed site is counted, even when
+// other filters keep it from being written.
+func batchSiteRollups(r report, sites rollups) {
+	for _, s := range sites {
+		r.send(&metric{
+			Table: "site_rollup",
+			Tags: map[string]string{
+				"site_name": s.siteName,
+				"source":    s.source,
+			},
+			Fields: map[string]interface{}{
+				"clients":          s.clients,
+				"clients_wired":    s.wired,
+				"clients_wireless": s.wireless,
+				"num_ap":           s.aps,
+				"num_sw":           s.switches,
+				"num_gw":           s.gateways,
+				"adopted":          s.adopted,
+				"offline":          s.offline,
+				"upgradable":       s.upgradable,
+				"rx_bytes-r":       s.rxBytesR,
+				"tx_bytes-r":       s.txBytesR,
+			},
+		})
+	}
+}
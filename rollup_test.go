@@ -0,0 +1,42 @@
+package influxunifi
+
+import "testing"
+
+func TestSiteRollup(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	mustReport(t, u, testMetrics(t))
+
+	if n := len(w.points("site_rollup")); n != 0 {
+		t.Errorf("site_rollup is off by default, got %d points", n)
+	}
+
+	// Clients dropped by other filters are still counted.
+	u, w, _ = testUnifi(t, &Config{SiteRollup: true, ExcludeNetworks: []string{"LAN"}})
+	mustReport(t, u, testMetrics(t))
+
+	points := w.points("site_rollup")
+	if len(points) != 1 {
+		t.Fatalf("expected one site_rollup point, got %d", len(points))
+	}
+
+	fields := pointFields(t, points[0])
+	for field, want := range map[string]int64{"clients": 1, "clients_wireless": 1, "num_sw": 1, "adopted": 1, "offline": 0} {
+		if fields[field] != want {
+			t.Errorf("%s = %v, want %d", field, fields[field], want)
+		}
+	}
+}
+
+func TestSiteRollupDisabledClients(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{SiteRollup: true, DisableClients: true})
+	mustReport(t, u, testMetrics(t))
+
+	if n := len(w.points("clients")); n != 0 {
+		t.Errorf("disable_clients wrote %d client points", n)
+	}
+
+	points := w.points("site_rollup")
+	if len(points) != 1 || pointFields(t, points[0])["clients"] != int64(1) {
+		t.Errorf("site_rollup did not count clients with disable_clients")
+	}
+}
@@ -0,0 +1,48 @@
+package influxunifi
+
+import (
+	"os"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/prometheus/common/version"
+)
+
+// batchSelfStats adds a point about this plugin's own run. It gets the static tags
+// and is routed like any other measurement, with measurement_db and rp_routes.
+// It is added after batching, so it does not count itself in the report totals.
+// points_batched is counted before the write, so it includes points that fail to write.
+// Alert on this measurement going quiet to catch a poller that stopped writing.
+// The version is a field, so an upgrade does not start a new series. Dropped counts
+// are written as dropped_<reason> fields, only for the reasons seen this interval.
+func (u *InfluxUnifi) batchSelfStats(r *Report) error {
+	host, _ := os.Hostname()
+	tags := map[string]string{
+		"host": host,
+	}
+	fields := map[string]interface{}{
+		"version":        version.Version,
+		"points_batched": r.Total,
+		"fields":         r.Fields,
+		"series":         r.Series,
+		"errors":         len(r.Errors),
+		"elapsed":        time.Since(r.Start).Seconds(),
+		"latency":        time.Since(r.Metrics.TS).Seconds(),
+	}
+
+	for reason, count := range r.Dropped {
+		fields["dropped_"+reason] = count
+	}
+
+	m := &metric{Table: "influxunifi", Tags: tags, Fields: fields}
+	u.addStaticTags(m)
+
+	pt, err := influx.NewPoint(m.Table, m.Tags, m.Fields, r.Metrics.TS)
+	if err != nil {
+		return err
+	}
+
+	r.bp[u.measurementBatch(m.Table)].AddPoint(pt)
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package influxunifi
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/version"
+	"github.com/unifi-poller/poller"
+)
+
+func TestSelfStatsVersionField(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	mustReport(t, u, &poller.Metrics{TS: testTime})
+
+	points := w.points("influxunifi")
+	if len(points) != 1 {
+		t.Fatalf("expected one influxunifi point, got %d", len(points))
+	}
+
+	if _, ok := points[0].Tags()["version"]; ok {
+		t.Error("version is a tag, want a field")
+	}
+
+	if v := pointFields(t, points[0])["version"]; v != version.Version {
+		t.Errorf("version field = %v, want %q", v, version.Version)
+	}
+}
+
+func TestSelfStatsDropped(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{ExcludeNetworks: []string{"LAN"}})
+	r := mustReport(t, u, testMetrics(t))
+
+	points := w.points("influxunifi")
+	if len(points) != 1 {
+		t.Fatalf("expected one influxunifi point, got %d", len(points))
+	}
+
+	fields := pointFields(t, points[0])
+	if fields["dropped_networks"] != int64(r.Dropped["networks"]) || r.Dropped["networks"] == 0 {
+		t.Errorf("dropped_networks = %v, report dropped = %v", fields["dropped_networks"], r.Dropped)
+	}
+
+	if _, ok := fields["filtered"]; ok {
+		t.Error("the old filtered field is still written")
+	}
+}
+
+func TestSelfStatsTagsAndRouting(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{
+		Tags:          map[string]string{"env": "lab"},
+		ControllerTag: "main",
+		MeasurementDB: map[string]string{"influxunifi": "poller"},
+	})
+	mustReport(t, u, &poller.Metrics{TS: testTime})
+
+	points := w.points("influxunifi")
+	if len(points) != 1 {
+		t.Fatalf("expected one influxunifi point, got %d", len(points))
+	}
+
+	if tags := points[0].Tags(); tags["env"] != "lab" || tags["controller"] != "main" {
+		t.Errorf("influxunifi tags = %v, want the static and controller tags", tags)
+	}
+
+	for _, bp := range w.batches {
+		for _, p := range bp.Points() {
+			if p.Name() == "influxunifi" && bp.Database() != "poller" {
+				t.Errorf("influxunifi written to %s, want poller", bp.Database())
+			}
+		}
+	}
+
+	if _, ok := pointFields(t, points[0])["points_batched"]; !ok {
+		t.Error("points_batched field missing")
+	}
+}
@@ -0,0 +1,106 @@
+package influxunifi
+
+import (
+	"sync"
+
+	"github.com/unifi-poller/unifi"
+)
+
+// clientSession is the last seen state of a client's association.
+type clientSession struct {
+	Start   int64
+	Last    int64
+	RxBytes int64
+	TxBytes int64
+	Client  *unifi.Client
+}
+
+// sessionStartSlack is how far a session start derived from last_seen and uptime may
+// move between intervals and still be the same session. The two values are not updated
+// together, so the derived start moves by a second or two every poll.
+const sessionStartSlack = 10 // seconds
+
+// sessionTracker keeps the open client sessions between intervals.
+type sessionTracker struct {
+	sync.Mutex
+	open map[string]*clientSession
+}
+
+// batchClientSessions generates a client_session datapoint for every client
+// session that ended since the previous interval. A session ends when a client
+// disappears or re-associates. Sessions start at the controller-provided
+// association time, so a session that spans a poller restart keeps its real
+// duration. Without an association time the start is last_seen minus uptime, and
+// the first start computed for a session is kept. Sessions that end while the
+// poller is not running are not recorded.
+func (u *InfluxUnifi) batchClientSessions(r report, clients []*unifi.Client) {
+	u.sessions.Lock()
+	defer u.sessions.Unlock()
+
+	seen := make(map[string]*clientSession)
+	sources := make(map[string]bool)
+
+	for _, c := range clients {
+		key := sessionKey(c)
+		sources[c.SourceName] = true
+		start := c.AssocTime
+		old := u.sessions.open[key]
+
+		if start == 0 {
+			start = c.LastSeen - c.Uptime
+
+			if old != nil && abs64(start-old.Start) <= sessionStartSlack {
+				start = old.Start
+			}
+		}
+
+		if old != nil && old.Start != start {
+			u.batchClientSession(r, old) // re-associated, the old session is over.
+		}
+
+		seen[key] = &clientSession{Start: start, Last: c.LastSeen, RxBytes: c.RxBytes, TxBytes: c.TxBytes, Client: c}
+	}
+
+	for key, s := range u.sessions.open {
+		// A controller that returned no clients probably failed to poll. Keep its sessions open.
+		if _, ok := seen[key]; !ok && sources[s.Client.SourceName] {
+			u.batchClientSession(r, s)
+		} else if !ok {
+			seen[key] = s
+		}
+	}
+
+	u.sessions.open = seen
+}
+
+// sessionKey returns the key for a client's session: controller, site and mac.
+func sessionKey(c *unifi.Client) string {
+	return c.SourceName + "\x00" + c.SiteName + "\x00" + c.Mac
+}
+
+func abs64(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+
+	return i
+}
+
+func (u *InfluxUnifi) batchClientSession(r report, s *clientSession) {
+	r.send(&metric{
+		Table: "client_session",
+		Tags: map[string]string{
+			"mac":       s.Client.Mac,
+			"site_name": s.Client.SiteName,
+			"source":    s.Client.SourceName,
+			"name":      u.clientName(s.Client),
+		},
+		Fields: map[string]interface{}{
+			"duration": s.Last - s.Start,
+			"rx_bytes": s.RxBytes,
+			"tx_bytes": s.TxBytes,
+			"ap":       s.Client.ApName,
+			"essid":    s.Client.Essid,
+		},
+	})
+}
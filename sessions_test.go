@@ -0,0 +1,78 @@
+package influxunifi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+)
+
+func sessionClient(mac string, lastSeen, uptime int64) *unifi.Client {
+	return &unifi.Client{SourceName: "https://unifi", SiteName: "default", Mac: mac, LastSeen: lastSeen, Uptime: uptime}
+}
+
+func TestSessionDerivedStartJitter(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	other := sessionClient("00:00:00:00:00:02", 1000, 500)
+
+	// Without assoc_time, the derived start moves from 900 to 901; that is not a new session.
+	mustReport(t, u, &poller.Metrics{TS: testTime, Clients: unifi.Clients{sessionClient("00:00:00:00:00:01", 1000, 100), other}})
+	mustReport(t, u, &poller.Metrics{TS: testTime, Clients: unifi.Clients{sessionClient("00:00:00:00:00:01", 1030, 129), other}})
+
+	if points := w.points("client_session"); len(points) != 0 {
+		t.Fatalf("session ended on start jitter: %v", points)
+	}
+
+	mustReport(t, u, &poller.Metrics{TS: testTime, Clients: unifi.Clients{other}})
+
+	points := w.points("client_session")
+	if len(points) != 1 {
+		t.Fatalf("expected one ended session, got %d", len(points))
+	}
+
+	if d := pointFields(t, points[0])["duration"]; d != int64(130) {
+		t.Errorf("duration = %v, want 130 (from the first start)", d)
+	}
+}
+
+func TestSessionReassociated(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+
+	mustReport(t, u, &poller.Metrics{TS: testTime, Clients: unifi.Clients{sessionClient("00:00:00:00:00:01", 1000, 100)}})
+	mustReport(t, u, &poller.Metrics{TS: testTime, Clients: unifi.Clients{sessionClient("00:00:00:00:00:01", 1030, 5)}})
+
+	if points := w.points("client_session"); len(points) != 1 {
+		t.Fatalf("expected the first session to end when uptime restarted, got %d points", len(points))
+	}
+}
+
+func TestSessionKeySeparator(t *testing.T) {
+	a := &unifi.Client{SourceName: "a", SiteName: "bc", Mac: "d"}
+	b := &unifi.Client{SourceName: "ab", SiteName: "c", Mac: "d"}
+
+	if sessionKey(a) == sessionKey(b) {
+		t.Errorf("session keys collide: %q", sessionKey(a))
+	}
+}
+
+func TestSessionKeptOnDeadline(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	other := sessionClient("00:00:00:00:00:02", 1000, 500)
+
+	mustReport(t, u, &poller.Metrics{TS: testTime, Clients: unifi.Clients{sessionClient("00:00:00:00:00:01", 1000, 100), other}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The client is gone, but the interval is abandoned before its session is ended.
+	if _, err := u.ReportMetricsContext(ctx, &poller.Metrics{TS: testTime, Clients: unifi.Clients{other}}); err == nil {
+		t.Fatal("expected an error with a canceled context")
+	}
+
+	mustReport(t, u, &poller.Metrics{TS: testTime, Clients: unifi.Clients{other}})
+
+	if points := w.points("client_session"); len(points) != 1 {
+		t.Errorf("expected the session end to be written by the next interval, got %d points", len(points))
+	}
+}
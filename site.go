@@ -1,6 +1,9 @@
 package influxunifi
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/unifi-poller/unifi"
 )
 
@@ -59,14 +62,21 @@ func (u *InfluxUnifi) batchSite(r report, s *unifi.Site) {
 
 func (u *InfluxUnifi) batchSiteDPI(r report, s *unifi.DPITable) {
 	for _, dpi := range s.ByApp {
+		if u.dpiExcluded(dpi.Cat) {
+			r.dropped("dpi_exclude_categories", 1)
+			continue
+		}
+
+		category, application := u.dpiNames(dpi.Cat, dpi.App)
+
 		r.send(&metric{
 			Table: "sitedpi",
-			Tags: map[string]string{
-				"category":    unifi.DPICats.Get(dpi.Cat),
-				"application": unifi.DPIApps.GetApp(dpi.Cat, dpi.App),
+			Tags: u.dpiIDTags(map[string]string{
+				"category":    category,
+				"application": application,
 				"site_name":   s.SiteName,
 				"source":      s.SourceName,
-			},
+			}, dpi),
 			Fields: map[string]interface{}{
 				"tx_packets": dpi.TxPackets,
 				"rx_packets": dpi.RxPackets,
@@ -76,3 +86,61 @@ func (u *InfluxUnifi) batchSiteDPI(r report, s *unifi.DPITable) {
 		)
 	}
 }
+
+// siteAllowed returns true if points for a site should be recorded.
+// Sites may be listed by their short name (default) or full site_name tag (Default (default)).
+// A site in exclude_sites is always dropped, even when it is also listed in sites.
+func (u *InfluxUnifi) siteAllowed(siteName string) bool {
+	short := siteName
+	if i := strings.LastIndex(siteName, " ("); i != -1 && strings.HasSuffix(siteName, ")") {
+		short = siteName[i+2 : len(siteName)-1]
+	}
+
+	if stringInSlice(siteName, u.ExcludeSites) || stringInSlice(short, u.ExcludeSites) {
+		return false
+	}
+
+	return len(u.Sites) == 0 || stringInSlice(siteName, u.Sites) || stringInSlice(short, u.Sites)
+}
+
+// dpiNames returns the category and application names for DPI IDs. IDs the
+// unifi library cannot name keep their Unknown_<id> name, so existing series are
+// not renamed. With disable_dpi_names set, all IDs are returned as numbers.
+func (u *InfluxUnifi) dpiNames(cat, app int) (string, string) {
+	if u.DisableDPINames {
+		return strconv.Itoa(cat), strconv.Itoa(cat<<16 + app)
+	}
+
+	return unifi.DPICats.Get(cat), unifi.DPIApps.GetApp(cat, app)
+}
+
+// dpiIDTags adds the numeric category_id and application_id tags when dpi_id_tags
+// is enabled. They keep a series stable when the unifi library renames an ID,
+// but they are off by default because every point gets two more tags.
+func (u *InfluxUnifi) dpiIDTags(tags map[string]string, dpi unifi.DPIData) map[string]string {
+	if u.DPIIDTags {
+		tags["category_id"] = strconv.Itoa(dpi.Cat)
+		tags["application_id"] = strconv.Itoa(dpi.Cat<<16 + dpi.App)
+	}
+
+	return tags
+}
+
+// dpiExcluded returns true if a DPI category is listed in dpi_exclude_categories.
+// Categories may be listed by name (case insensitive) or by numeric ID.
+func (u *InfluxUnifi) dpiExcluded(cat int) bool {
+	if len(u.DPIExcludeCategories) == 0 {
+		return false
+	}
+
+	id := strconv.Itoa(cat)
+	name := unifi.DPICats[cat]
+
+	for _, c := range u.DPIExcludeCategories {
+		if c == id || (name != "" && strings.EqualFold(c, name)) {
+			return true
+		}
+	}
+
+	return false
+}
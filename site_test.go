@@ -0,0 +1,131 @@
+package influxunifi
+
+import (
+	"testing"
+
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+)
+
+func dpiMetrics() *poller.Metrics {
+	return &poller.Metrics{TS: testTime, SitesDPI: []*unifi.DPITable{{
+		SiteName:   "default",
+		SourceName: "https://unifi",
+		ByApp:      []unifi.DPIData{{Cat: 5, App: 10, TxBytes: 100, RxBytes: 200}},
+	}}}
+}
+
+func TestDPIIDTags(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		u, w, _ := testUnifi(t, &Config{DPIIDTags: enabled})
+		mustReport(t, u, dpiMetrics())
+
+		points := w.points("sitedpi")
+		if len(points) != 1 {
+			t.Fatalf("expected one sitedpi point, got %d", len(points))
+		}
+
+		tags := points[0].Tags()
+		if _, ok := tags["category_id"]; ok != enabled {
+			t.Errorf("dpi_id_tags=%v: category_id tag present = %v", enabled, ok)
+		}
+
+		if enabled && tags["application_id"] != "327690" {
+			t.Errorf("application_id = %q, want 327690", tags["application_id"])
+		}
+	}
+}
+
+func TestDPINames(t *testing.T) {
+	tests := []struct {
+		disabled    bool
+		cat, app    int
+		category    string
+		application string
+	}{
+		{cat: 5, app: 10, category: unifi.DPICats[5], application: unifi.DPIApps.GetApp(5, 10)},
+		{cat: 250, app: 9999, category: "Unknown_250", application: "Unknown_16393999"},
+		{disabled: true, cat: 5, app: 10, category: "5", application: "327690"},
+	}
+
+	for _, test := range tests {
+		u, _, _ := testUnifi(t, &Config{DisableDPINames: test.disabled})
+
+		category, application := u.dpiNames(test.cat, test.app)
+		if category != test.category || application != test.application {
+			t.Errorf("dpiNames(%d, %d) = %q, %q, want %q, %q",
+				test.cat, test.app, category, application, test.category, test.application)
+		}
+	}
+}
+
+func TestSiteAllowed(t *testing.T) {
+	for name, test := range map[string]struct {
+		c       *Config
+		allowed map[string]bool
+	}{
+		"no filters": {c: &Config{}, allowed: map[string]bool{"Default (default)": true, "Office (abc123)": true}},
+		"short name": {c: &Config{Sites: []string{"default"}}, allowed: map[string]bool{"Default (default)": true, "Office (abc123)": false}},
+		"full name":  {c: &Config{Sites: []string{"Office (abc123)"}}, allowed: map[string]bool{"Default (default)": false, "Office (abc123)": true}},
+		"exclude":    {c: &Config{ExcludeSites: []string{"abc123"}}, allowed: map[string]bool{"Default (default)": true, "Office (abc123)": false}},
+		"exclude wins": {
+			c:       &Config{Sites: []string{"default", "abc123"}, ExcludeSites: []string{"abc123"}},
+			allowed: map[string]bool{"Default (default)": true, "Office (abc123)": false},
+		},
+	} {
+		u, _, _ := testUnifi(t, test.c)
+
+		for site, want := range test.allowed {
+			if got := u.siteAllowed(site); got != want {
+				t.Errorf("%s: siteAllowed(%q) = %v, want %v", name, site, got, want)
+			}
+		}
+	}
+}
+
+func TestSitesFilterPoints(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{Sites: []string{"office"}})
+	r := mustReport(t, u, testMetrics(t))
+
+	for _, p := range w.points("") {
+		if p.Name() != "influxunifi" {
+			t.Errorf("%s point written for a site that is not listed", p.Name())
+		}
+	}
+
+	if r.Dropped["sites"] == 0 {
+		t.Error("nothing was counted as dropped by the sites filter")
+	}
+}
+
+func TestDPIExcludeCategories(t *testing.T) {
+	u, w, _ := testUnifi(t, &Config{DPIExcludeCategories: []string{"email messaging"}})
+	m := testMetrics(t)
+	kept := unifi.DPIData{Cat: 3, App: 5, TxBytes: 50}
+	m.ClientsDPI[0].ByApp = append(m.ClientsDPI[0].ByApp, kept)
+	m.SitesDPI[0].ByApp = append(m.SitesDPI[0].ByApp, kept)
+
+	r := mustReport(t, u, m)
+
+	for _, table := range []string{"clientdpi", "sitedpi"} {
+		points := w.points(table)
+		if len(points) == 0 {
+			t.Fatalf("no %s points for the category that is not excluded", table)
+		}
+
+		for _, p := range points {
+			if category := p.Tags()["category"]; category == unifi.DPICats[5] {
+				t.Errorf("%s point written for the excluded category, mac: %s", table, p.Tags()["mac"])
+			}
+
+			// Only the kept category's bytes are in the points, and in the totals.
+			if tx := pointFields(t, p)["tx_bytes"]; tx != kept.TxBytes {
+				t.Errorf("%s %s tx_bytes = %v, want %d", table, p.Tags()["mac"], tx, kept.TxBytes)
+			}
+		}
+	}
+
+	if r.Dropped["dpi_exclude_categories"] != 2 {
+		t.Errorf("dropped = %v, want dpi_exclude_categories=2", r.Dropped)
+	}
+}
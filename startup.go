@@ -0,0 +1,67 @@
+package influxunifi
+
+import (
+	"context"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/version"
+)
+
+// Values for the startup_check setting.
+const (
+	startupWarn  = "warn"
+	startupAbort = "abort"
+	startupOff   = "off"
+)
+
+// startupCheck writes one point to the influxunifi_startup measurement, so a broken
+// write path shows up in the log at startup instead of as an empty dashboard.
+// The point is the same every time, apart from its timestamp. A failure stops
+// the output only when startup_check is abort.
+func (u *InfluxUnifi) startupCheck() error {
+	if u.StartupCheck == startupOff || u.DryRun || (u.file != nil && u.OutputFileOnly) {
+		return nil
+	}
+
+	writer, name := u.output()
+
+	err := u.writeStartup(writer)
+	if err == nil {
+		u.Logf("✓ connected to InfluxDB at %s, db %s", name, u.DB)
+		return nil
+	}
+
+	if u.StartupCheck == startupAbort {
+		return errors.Wrapf(err, "InfluxDB startup write to %s", name)
+	}
+
+	u.LogErrorf("InfluxDB startup write to %s failed (continuing): %v", name, err)
+
+	return nil
+}
+
+// writeStartup writes the startup point to the default database and retention policy.
+func (u *InfluxUnifi) writeStartup(writer Writer) error {
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{
+		Database:         u.DB,
+		Precision:        u.Precision,
+		RetentionPolicy:  u.RetentionPolicy,
+		WriteConsistency: u.Consistency,
+	})
+	if err != nil {
+		return errors.Wrap(err, "influx.NewBatchPoint")
+	}
+
+	fields := map[string]interface{}{"poller_version": version.Version, "ok": true}
+
+	pt, err := influx.NewPoint("influxunifi_startup", nil, fields, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "influx.NewPoint")
+	}
+
+	bp.AddPoint(pt)
+
+	return writer.WritePoints(context.Background(), bp)
+}
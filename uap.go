@@ -21,7 +21,21 @@ func (u *InfluxUnifi) batchUAP(r report, s *unifi.UAP) {
 		"serial":    s.Serial,
 		"type":      s.Type,
 	}
-	fields := Combine(u.processUAPstats(s.Stat.Ap), u.batchSysStats(s.SysStats, s.SystemStats))
+
+	if u.isOffline(s.State) {
+		batchStatePoint(r, tags, s.State, s.Uptime, s.LastSeen, "offline")
+		return
+	}
+
+	if u.isIdle(s.NumSta.Val, s.BytesR.Val) {
+		batchStatePoint(r, tags, s.State, s.Uptime, s.LastSeen, "idle")
+		return
+	}
+
+	fields := make(map[string]interface{}, deviceFields)
+	u.addUAPstats(fields, s.Stat.Ap)
+	u.addSysStats(fields, s.SysStats, s.SystemStats)
+	u.addDeviceState(fields, s.State, s.Cfgversion, s.KnownCfgversion, s.LedOverride)
 	fields["ip"] = s.IP
 	fields["bytes"] = s.Bytes.Val
 	fields["last_seen"] = s.LastSeen.Val
@@ -32,52 +46,64 @@ func (u *InfluxUnifi) batchUAP(r report, s *unifi.UAP) {
 	fields["guest-num_sta"] = int(s.GuestNumSta.Val)
 	fields["num_sta"] = s.NumSta.Val
 
+	if s.Satisfaction.Txt != "" {
+		fields["satisfaction"] = s.Satisfaction.Val
+	}
+
+	errs, packets := apHealth(s.Stat.Ap)
+	fields["health_score"] = u.healthScore(deviceHealth{
+		Uptime:  s.Uptime.Val,
+		CPU:     s.SystemStats.CPU.Val,
+		Mem:     s.SystemStats.Mem.Val,
+		Errors:  errs,
+		Packets: packets,
+	})
+
 	r.send(&metric{Table: "uap", Tags: tags, Fields: fields})
+	batchUplink(r, tags, s.Uplink.UplinkMac, s.Uplink.UplinkRemotePort, s.Uplink.Speed, s.Uplink.Type)
 	u.processRadTable(r, tags, s.RadioTable, s.RadioTableStats)
 	u.processVAPTable(r, tags, s.VapTable)
 	u.batchPortTable(r, tags, s.PortTable)
 }
 
-func (u *InfluxUnifi) processUAPstats(ap *unifi.Ap) map[string]interface{} {
+func (u *InfluxUnifi) addUAPstats(fields map[string]interface{}, ap *unifi.Ap) {
 	if ap == nil {
-		return map[string]interface{}{}
+		return
 	}
 
 	// Accumulative Statistics.
-	return map[string]interface{}{
-		"stat_user-rx_packets":  ap.UserRxPackets.Val,
-		"stat_guest-rx_packets": ap.GuestRxPackets.Val,
-		"stat_rx_packets":       ap.RxPackets.Val,
-		"stat_user-rx_bytes":    ap.UserRxBytes.Val,
-		"stat_guest-rx_bytes":   ap.GuestRxBytes.Val,
-		"stat_rx_bytes":         ap.RxBytes.Val,
-		"stat_user-rx_errors":   ap.UserRxErrors.Val,
-		"stat_guest-rx_errors":  ap.GuestRxErrors.Val,
-		"stat_rx_errors":        ap.RxErrors.Val,
-		"stat_user-rx_dropped":  ap.UserRxDropped.Val,
-		"stat_guest-rx_dropped": ap.GuestRxDropped.Val,
-		"stat_rx_dropped":       ap.RxDropped.Val,
-		"stat_user-rx_crypts":   ap.UserRxCrypts.Val,
-		"stat_guest-rx_crypts":  ap.GuestRxCrypts.Val,
-		"stat_rx_crypts":        ap.RxCrypts.Val,
-		"stat_user-rx_frags":    ap.UserRxFrags.Val,
-		"stat_guest-rx_frags":   ap.GuestRxFrags.Val,
-		"stat_rx_frags":         ap.RxFrags.Val,
-		"stat_user-tx_packets":  ap.UserTxPackets.Val,
-		"stat_guest-tx_packets": ap.GuestTxPackets.Val,
-		"stat_tx_packets":       ap.TxPackets.Val,
-		"stat_user-tx_bytes":    ap.UserTxBytes.Val,
-		"stat_guest-tx_bytes":   ap.GuestTxBytes.Val,
-		"stat_tx_bytes":         ap.TxBytes.Val,
-		"stat_user-tx_errors":   ap.UserTxErrors.Val,
-		"stat_guest-tx_errors":  ap.GuestTxErrors.Val,
-		"stat_tx_errors":        ap.TxErrors.Val,
-		"stat_user-tx_dropped":  ap.UserTxDropped.Val,
-		"stat_guest-tx_dropped": ap.GuestTxDropped.Val,
-		"stat_tx_dropped":       ap.TxDropped.Val,
-		"stat_user-tx_retries":  ap.UserTxRetries.Val,
-		"stat_guest-tx_retries": ap.GuestTxRetries.Val,
-	}
+	fields["stat_user-rx_packets"] = ap.UserRxPackets.Val
+	fields["stat_guest-rx_packets"] = ap.GuestRxPackets.Val
+	fields["stat_rx_packets"] = ap.RxPackets.Val
+	fields["stat_user-rx_bytes"] = ap.UserRxBytes.Val
+	fields["stat_guest-rx_bytes"] = ap.GuestRxBytes.Val
+	fields["stat_rx_bytes"] = ap.RxBytes.Val
+	fields["stat_user-rx_errors"] = ap.UserRxErrors.Val
+	fields["stat_guest-rx_errors"] = ap.GuestRxErrors.Val
+	fields["stat_rx_errors"] = ap.RxErrors.Val
+	fields["stat_user-rx_dropped"] = ap.UserRxDropped.Val
+	fields["stat_guest-rx_dropped"] = ap.GuestRxDropped.Val
+	fields["stat_rx_dropped"] = ap.RxDropped.Val
+	fields["stat_user-rx_crypts"] = ap.UserRxCrypts.Val
+	fields["stat_guest-rx_crypts"] = ap.GuestRxCrypts.Val
+	fields["stat_rx_crypts"] = ap.RxCrypts.Val
+	fields["stat_user-rx_frags"] = ap.UserRxFrags.Val
+	fields["stat_guest-rx_frags"] = ap.GuestRxFrags.Val
+	fields["stat_rx_frags"] = ap.RxFrags.Val
+	fields["stat_user-tx_packets"] = ap.UserTxPackets.Val
+	fields["stat_guest-tx_packets"] = ap.GuestTxPackets.Val
+	fields["stat_tx_packets"] = ap.TxPackets.Val
+	fields["stat_user-tx_bytes"] = ap.UserTxBytes.Val
+	fields["stat_guest-tx_bytes"] = ap.GuestTxBytes.Val
+	fields["stat_tx_bytes"] = ap.TxBytes.Val
+	fields["stat_user-tx_errors"] = ap.UserTxErrors.Val
+	fields["stat_guest-tx_errors"] = ap.GuestTxErrors.Val
+	fields["stat_tx_errors"] = ap.TxErrors.Val
+	fields["stat_user-tx_dropped"] = ap.UserTxDropped.Val
+	fields["stat_guest-tx_dropped"] = ap.GuestTxDropped.Val
+	fields["stat_tx_dropped"] = ap.TxDropped.Val
+	fields["stat_user-tx_retries"] = ap.UserTxRetries.Val
+	fields["stat_guest-tx_retries"] = ap.GuestTxRetries.Val
 }
 
 // processVAPTable creates points for Wifi Radios. This works with several types of UAP-capable devices.
@@ -180,6 +206,10 @@ func (u *InfluxUnifi) processRadTable(r report, t map[string]string, rt unifi.Ra
 				fields["tx_retries"] = t.TxRetries.Val
 				fields["user-num_sta"] = t.UserNumSta.Val
 
+				if t.Satisfaction.Txt != "" {
+					fields["satisfaction"] = t.Satisfaction.Val
+				}
+
 				break
 			}
 		}
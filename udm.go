@@ -5,8 +5,14 @@ import (
 )
 
 // Combine concatenates N maps. This will delete things if not used with caution.
+// The output map is allocated once at its final size to avoid growing it while copying.
 func Combine(in ...map[string]interface{}) map[string]interface{} {
-	out := make(map[string]interface{})
+	size := 0
+	for i := range in {
+		size += len(in[i])
+	}
+
+	out := make(map[string]interface{}, size)
 
 	for i := range in {
 		for k := range in[i] {
@@ -17,29 +23,43 @@ func Combine(in ...map[string]interface{}) map[string]interface{} {
 	return out
 }
 
-// batchSysStats is used by all device types.
-func (u *InfluxUnifi) batchSysStats(s unifi.SysStats, ss unifi.SystemStats) map[string]interface{} {
-	return map[string]interface{}{
-		"loadavg_1":     s.Loadavg1.Val,
-		"loadavg_5":     s.Loadavg5.Val,
-		"loadavg_15":    s.Loadavg15.Val,
-		"mem_used":      s.MemUsed.Val,
-		"mem_buffer":    s.MemBuffer.Val,
-		"mem_total":     s.MemTotal.Val,
-		"cpu":           ss.CPU.Val,
-		"mem":           ss.Mem.Val,
-		"system_uptime": ss.Uptime.Val,
-	}
+// deviceFields is the size hint for device field maps. It leaves room for every
+// field a device point gets, so the map is allocated once and never grows.
+const deviceFields = 64
+
+// addSysStats adds the system stats used by all device types to fields.
+func (u *InfluxUnifi) addSysStats(fields map[string]interface{}, s unifi.SysStats, ss unifi.SystemStats) {
+	fields["loadavg_1"] = s.Loadavg1.Val
+	fields["loadavg_5"] = s.Loadavg5.Val
+	fields["loadavg_15"] = s.Loadavg15.Val
+	fields["mem_used"] = s.MemUsed.Val
+	fields["mem_buffer"] = s.MemBuffer.Val
+	fields["mem_total"] = s.MemTotal.Val
+	fields["cpu"] = ss.CPU.Val
+	fields["mem"] = ss.Mem.Val
+	fields["system_uptime"] = ss.Uptime.Val
 }
 
-func (u *InfluxUnifi) batchUDMtemps(temps []unifi.Temperature) map[string]interface{} {
-	output := make(map[string]interface{})
+// Device states reported by the controller. There are more, but these are the ones we use.
+const (
+	deviceStateProvisioning = 5
+)
 
-	for _, t := range temps {
-		output["temp_"+t.Name] = t.Value
+// addDeviceState adds the maintenance signals used by all device types to fields.
+// config_network_pending is true when the device has not applied the latest config version.
+func (u *InfluxUnifi) addDeviceState(fields map[string]interface{}, state unifi.FlexInt, cfg, knownCfg, led string) {
+	fields["provisioning"] = state.Val == deviceStateProvisioning
+	fields["config_network_pending"] = knownCfg != "" && cfg != knownCfg
+
+	if led != "" {
+		fields["led_override"] = led
 	}
+}
 
-	return output
+func (u *InfluxUnifi) addUDMtemps(fields map[string]interface{}, temps []unifi.Temperature) {
+	for _, t := range temps {
+		fields["temp_"+t.Name] = t.Value
+	}
 }
 
 // batchUDM generates Unifi Gateway datapoints for InfluxDB.
@@ -59,28 +79,46 @@ func (u *InfluxUnifi) batchUDM(r report, s *unifi.UDM) { // nolint: funlen
 		"serial":    s.Serial,
 		"type":      s.Type,
 	}
-	fields := Combine(
-		u.batchUDMtemps(s.Temperatures),
-		u.batchUSGstats(s.SpeedtestStatus, s.Stat.Gw, s.Uplink),
-		u.batchSysStats(s.SysStats, s.SystemStats),
-		map[string]interface{}{
-			"source":        s.SourceName,
-			"ip":            s.IP,
-			"bytes":         s.Bytes.Val,
-			"last_seen":     s.LastSeen.Val,
-			"license_state": s.LicenseState,
-			"guest-num_sta": s.GuestNumSta.Val,
-			"rx_bytes":      s.RxBytes.Val,
-			"tx_bytes":      s.TxBytes.Val,
-			"uptime":        s.Uptime.Val,
-			"state":         s.State.Val,
-			"user-num_sta":  s.UserNumSta.Val,
-			"version":       s.Version,
-			"num_desktop":   s.NumDesktop.Val,
-			"num_handheld":  s.NumHandheld.Val,
-			"num_mobile":    s.NumMobile.Val,
-		},
-	)
+
+	if u.isOffline(s.State) {
+		batchStatePoint(r, tags, s.State, s.Uptime, s.LastSeen, "offline")
+		return
+	}
+
+	fields := make(map[string]interface{}, deviceFields)
+	u.addUDMtemps(fields, s.Temperatures)
+	u.addUSGstats(fields, s.SpeedtestStatus, s.Stat.Gw, s.Uplink)
+	u.addSysStats(fields, s.SysStats, s.SystemStats)
+	u.addDeviceState(fields, s.State, s.Cfgversion, s.KnownCfgversion, "")
+	fields["source"] = s.SourceName
+	fields["ip"] = s.IP
+	fields["bytes"] = s.Bytes.Val
+	fields["last_seen"] = s.LastSeen.Val
+	fields["license_state"] = s.LicenseState
+	fields["guest-num_sta"] = s.GuestNumSta.Val
+	fields["rx_bytes"] = s.RxBytes.Val
+	fields["tx_bytes"] = s.TxBytes.Val
+	fields["uptime"] = s.Uptime.Val
+	fields["state"] = s.State.Val
+	fields["user-num_sta"] = s.UserNumSta.Val
+	fields["version"] = s.Version
+	fields["num_desktop"] = s.NumDesktop.Val
+	fields["num_handheld"] = s.NumHandheld.Val
+	fields["num_mobile"] = s.NumMobile.Val
+
+	if len(s.Temperatures) > 0 {
+		fields["overheating"] = s.Overheating.Val
+	}
+
+	errs, packets := gwHealth(s.Stat.Gw)
+	fields["health_score"] = u.healthScore(deviceHealth{
+		Uptime:  s.Uptime.Val,
+		CPU:     s.SystemStats.CPU.Val,
+		Mem:     s.SystemStats.Mem.Val,
+		Errors:  errs,
+		Packets: packets,
+		Temp:    maxTemp(s.Temperatures),
+	})
 
 	r.send(&metric{Table: "usg", Tags: tags, Fields: fields})
 	u.batchNetTable(r, tags, s.NetworkTable)
@@ -96,17 +134,15 @@ func (u *InfluxUnifi) batchUDM(r report, s *unifi.UDM) { // nolint: funlen
 		"serial":    s.Serial,
 		"type":      s.Type,
 	}
-	fields = Combine(
-		u.batchUSWstat(s.Stat.Sw),
-		map[string]interface{}{
-			"guest-num_sta": s.GuestNumSta.Val,
-			"ip":            s.IP,
-			"bytes":         s.Bytes.Val,
-			"last_seen":     s.LastSeen.Val,
-			"rx_bytes":      s.RxBytes.Val,
-			"tx_bytes":      s.TxBytes.Val,
-			"uptime":        s.Uptime.Val,
-		})
+	fields = make(map[string]interface{}, deviceFields)
+	u.addUSWstat(fields, s.Stat.Sw)
+	fields["guest-num_sta"] = s.GuestNumSta.Val
+	fields["ip"] = s.IP
+	fields["bytes"] = s.Bytes.Val
+	fields["last_seen"] = s.LastSeen.Val
+	fields["rx_bytes"] = s.RxBytes.Val
+	fields["tx_bytes"] = s.TxBytes.Val
+	fields["uptime"] = s.Uptime.Val
 
 	r.send(&metric{Table: "usw", Tags: tags, Fields: fields})
 	u.batchPortTable(r, tags, s.PortTable) // udm has a usw in it.
@@ -125,7 +161,8 @@ func (u *InfluxUnifi) batchUDM(r report, s *unifi.UDM) { // nolint: funlen
 		"serial":    s.Serial,
 		"type":      s.Type,
 	}
-	fields = u.processUAPstats(s.Stat.Ap)
+	fields = make(map[string]interface{}, deviceFields)
+	u.addUAPstats(fields, s.Stat.Ap)
 	fields["ip"] = s.IP
 	fields["bytes"] = s.Bytes.Val
 	fields["last_seen"] = s.LastSeen.Val
@@ -138,6 +175,13 @@ func (u *InfluxUnifi) batchUDM(r report, s *unifi.UDM) { // nolint: funlen
 	fields["num_sta"] = s.NumSta.Val
 
 	r.send(&metric{Table: "uap", Tags: tags, Fields: fields})
-	u.processRadTable(r, tags, *s.RadioTable, *s.RadioTableStats)
-	u.processVAPTable(r, tags, *s.VapTable)
+
+	// Some UDM models and firmwares omit the radio and VAP tables.
+	if s.RadioTable != nil && s.RadioTableStats != nil {
+		u.processRadTable(r, tags, *s.RadioTable, *s.RadioTableStats)
+	}
+
+	if s.VapTable != nil {
+		u.processVAPTable(r, tags, *s.VapTable)
+	}
 }
@@ -0,0 +1,35 @@
+package influxunifi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+)
+
+// UDM-SE gateways are returned by the controller as UDMs, and batched by batchUDM.
+func TestUDMSE(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	s := &unifi.UDM{}
+
+	err := json.Unmarshal([]byte(`{"mac":"00:00:00:00:00:04","name":"gw","model":"UDMPROSE","adopted":true,"state":1}`), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.SourceName, s.SiteName = "https://unifi", "Default (default)"
+	mustReport(t, u, &poller.Metrics{TS: testTime, Devices: &unifi.Devices{UDMs: []*unifi.UDM{s}}})
+
+	for _, table := range []string{"usg", "usw"} {
+		points := w.points(table)
+		if len(points) != 1 {
+			t.Errorf("expected one %s point for a UDM-SE, got %d", table, len(points))
+			continue
+		}
+
+		if model := points[0].Tags()["model"]; model != "UDMPROSE" {
+			t.Errorf("%s model tag = %q, want UDMPROSE", table, model)
+		}
+	}
+}
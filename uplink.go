@@ -0,0 +1,33 @@
+package influxunifi
+
+import (
+	"strconv"
+
+	"github.com/unifi-poller/unifi"
+)
+
+// batchUplink generates an uplink datapoint linking a device to the device it
+// uplinks through, for topology and mesh dashboards. The remote port is only
+// known for access points. Devices without an uplink device, like the gateway,
+// get no point.
+func batchUplink(r report, t map[string]string, mac string, remotePort int, speed unifi.FlexInt, linkType string) {
+	if mac == "" {
+		return
+	}
+
+	tags := map[string]string{
+		"mac":         t["mac"],
+		"site_name":   t["site_name"],
+		"source":      t["source"],
+		"name":        t["name"],
+		"type":        t["type"],
+		"uplink_mac":  mac,
+		"uplink_type": linkType,
+	}
+
+	if remotePort > 0 {
+		tags["uplink_remote_port"] = strconv.Itoa(remotePort)
+	}
+
+	r.send(&metric{Table: "uplink", Tags: tags, Fields: map[string]interface{}{"uplink_speed": speed.Val}})
+}
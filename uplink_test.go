@@ -0,0 +1,66 @@
+package influxunifi
+
+import (
+	"testing"
+
+	"github.com/unifi-poller/unifi"
+)
+
+func TestUplinkPoints(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	m := testMetrics(t)
+	m.Devices.USWs[0].LastUplink.UplinkMac = "00:00:00:00:00:04"
+	m.Devices.USWs[0].Uplink.Speed.Val = 1000
+	m.Devices.USWs[0].Uplink.Type = "wire"
+
+	ap := &unifi.UAP{SourceName: "https://unifi", SiteName: "default", Mac: "00:00:00:00:00:05", Name: "ap"}
+	ap.Adopted.Val = true
+	ap.State.Val = 1
+	ap.Stat.Ap = &unifi.Ap{}
+	ap.Uplink.UplinkMac = "00:00:00:00:00:03"
+	ap.Uplink.UplinkRemotePort = 7
+	ap.Uplink.Type = "wire"
+
+	// A device without an uplink device gets no point.
+	gw := &unifi.UAP{SourceName: "https://unifi", SiteName: "default", Mac: "00:00:00:00:00:06", Name: "gw"}
+	gw.Adopted.Val = true
+	gw.State.Val = 1
+	gw.Stat.Ap = &unifi.Ap{}
+	m.Devices.UAPs = []*unifi.UAP{ap, gw}
+
+	mustReport(t, u, m)
+
+	points := w.points("uplink")
+	if len(points) != 2 {
+		t.Fatalf("got %d uplink points, want 2", len(points))
+	}
+
+	want := map[string]map[string]string{
+		"00:00:00:00:00:03": {"uplink_mac": "00:00:00:00:00:04", "uplink_remote_port": ""},
+		"00:00:00:00:00:05": {"uplink_mac": "00:00:00:00:00:03", "uplink_remote_port": "7"},
+	}
+
+	for _, p := range points {
+		tags := p.Tags()
+
+		exp, ok := want[tags["mac"]]
+		if !ok {
+			t.Errorf("unexpected uplink point for %s", tags["mac"])
+			continue
+		}
+
+		for k, v := range exp {
+			if tags[k] != v {
+				t.Errorf("%s: tag %s = %q, want %q", tags["mac"], k, tags[k], v)
+			}
+		}
+
+		if tags["uplink_type"] != "wire" {
+			t.Errorf("%s: uplink_type = %q, want wire", tags["mac"], tags["uplink_type"])
+		}
+
+		if speed := pointFields(t, p)["uplink_speed"]; tags["mac"] == "00:00:00:00:00:03" && speed != 1000.0 {
+			t.Errorf("uplink_speed = %v, want 1000", speed)
+		}
+	}
+}
@@ -21,53 +21,64 @@ func (u *InfluxUnifi) batchUSG(r report, s *unifi.USG) {
 		"serial":    s.Serial,
 		"type":      s.Type,
 	}
-	fields := Combine(
-		u.batchSysStats(s.SysStats, s.SystemStats),
-		u.batchUSGstats(s.SpeedtestStatus, s.Stat.Gw, s.Uplink),
-		map[string]interface{}{
-			"ip":            s.IP,
-			"bytes":         s.Bytes.Val,
-			"last_seen":     s.LastSeen.Val,
-			"license_state": s.LicenseState,
-			"guest-num_sta": s.GuestNumSta.Val,
-			"rx_bytes":      s.RxBytes.Val,
-			"tx_bytes":      s.TxBytes.Val,
-			"uptime":        s.Uptime.Val,
-			"state":         s.State.Val,
-			"user-num_sta":  s.UserNumSta.Val,
-			"version":       s.Version,
-			"num_desktop":   s.NumDesktop.Val,
-			"num_handheld":  s.NumHandheld.Val,
-			"num_mobile":    s.NumMobile.Val,
-			//			"speedtest_rundate": time.Unix(int64(s.SpeedtestStatus.Rundate.Val), 0).String(),
-		},
-	)
+
+	if u.isOffline(s.State) {
+		batchStatePoint(r, tags, s.State, s.Uptime, s.LastSeen, "offline")
+		return
+	}
+
+	fields := make(map[string]interface{}, deviceFields)
+	u.addSysStats(fields, s.SysStats, s.SystemStats)
+	u.addUSGstats(fields, s.SpeedtestStatus, s.Stat.Gw, s.Uplink)
+	u.addDeviceState(fields, s.State, s.Cfgversion, s.KnownCfgversion, s.LedOverride)
+	fields["ip"] = s.IP
+	fields["bytes"] = s.Bytes.Val
+	fields["last_seen"] = s.LastSeen.Val
+	fields["license_state"] = s.LicenseState
+	fields["guest-num_sta"] = s.GuestNumSta.Val
+	fields["rx_bytes"] = s.RxBytes.Val
+	fields["tx_bytes"] = s.TxBytes.Val
+	fields["uptime"] = s.Uptime.Val
+	fields["state"] = s.State.Val
+	fields["user-num_sta"] = s.UserNumSta.Val
+	fields["version"] = s.Version
+	fields["num_desktop"] = s.NumDesktop.Val
+	fields["num_handheld"] = s.NumHandheld.Val
+	fields["num_mobile"] = s.NumMobile.Val
+	// fields["speedtest_rundate"] = time.Unix(int64(s.SpeedtestStatus.Rundate.Val), 0).String()
+
+	errs, packets := gwHealth(s.Stat.Gw)
+	fields["health_score"] = u.healthScore(deviceHealth{
+		Uptime:  s.Uptime.Val,
+		CPU:     s.SystemStats.CPU.Val,
+		Mem:     s.SystemStats.Mem.Val,
+		Errors:  errs,
+		Packets: packets,
+	})
 
 	r.send(&metric{Table: "usg", Tags: tags, Fields: fields})
 	u.batchNetTable(r, tags, s.NetworkTable)
 	u.batchUSGwans(r, tags, s.Wan1, s.Wan2)
 }
 
-func (u *InfluxUnifi) batchUSGstats(ss unifi.SpeedtestStatus, gw *unifi.Gw, ul unifi.Uplink) map[string]interface{} {
+func (u *InfluxUnifi) addUSGstats(fields map[string]interface{}, ss unifi.SpeedtestStatus, gw *unifi.Gw, ul unifi.Uplink) {
 	if gw == nil {
-		return map[string]interface{}{}
+		return
 	}
 
-	return map[string]interface{}{
-		"uplink_latency":                 ul.Latency.Val,
-		"uplink_speed":                   ul.Speed.Val,
-		"speedtest-status_latency":       ss.Latency.Val,
-		"speedtest-status_runtime":       ss.Runtime.Val,
-		"speedtest-status_rundate":       ss.Rundate.Val,
-		"speedtest-status_ping":          ss.StatusPing.Val,
-		"speedtest-status_xput_download": ss.XputDownload.Val,
-		"speedtest-status_xput_upload":   ss.XputUpload.Val,
-		"lan-rx_bytes":                   gw.LanRxBytes.Val,
-		"lan-rx_packets":                 gw.LanRxPackets.Val,
-		"lan-tx_bytes":                   gw.LanTxBytes.Val,
-		"lan-tx_packets":                 gw.LanTxPackets.Val,
-		"lan-rx_dropped":                 gw.LanRxDropped.Val,
-	}
+	fields["uplink_latency"] = ul.Latency.Val
+	fields["uplink_speed"] = ul.Speed.Val
+	fields["speedtest-status_latency"] = ss.Latency.Val
+	fields["speedtest-status_runtime"] = ss.Runtime.Val
+	fields["speedtest-status_rundate"] = ss.Rundate.Val
+	fields["speedtest-status_ping"] = ss.StatusPing.Val
+	fields["speedtest-status_xput_download"] = ss.XputDownload.Val
+	fields["speedtest-status_xput_upload"] = ss.XputUpload.Val
+	fields["lan-rx_bytes"] = gw.LanRxBytes.Val
+	fields["lan-rx_packets"] = gw.LanRxPackets.Val
+	fields["lan-tx_bytes"] = gw.LanTxBytes.Val
+	fields["lan-tx_packets"] = gw.LanTxPackets.Val
+	fields["lan-rx_dropped"] = gw.LanRxDropped.Val
 }
 
 func (u *InfluxUnifi) batchUSGwans(r report, tags map[string]string, wans ...unifi.Wan) {
@@ -21,46 +21,95 @@ func (u *InfluxUnifi) batchUSW(r report, s *unifi.USW) {
 		"serial":    s.Serial,
 		"type":      s.Type,
 	}
-	fields := Combine(
-		u.batchUSWstat(s.Stat.Sw),
-		u.batchSysStats(s.SysStats, s.SystemStats),
-		map[string]interface{}{
-			"guest-num_sta":       s.GuestNumSta.Val,
-			"ip":                  s.IP,
-			"bytes":               s.Bytes.Val,
-			"fan_level":           s.FanLevel.Val,
-			"general_temperature": s.GeneralTemperature.Val,
-			"last_seen":           s.LastSeen.Val,
-			"rx_bytes":            s.RxBytes.Val,
-			"tx_bytes":            s.TxBytes.Val,
-			"uptime":              s.Uptime.Val,
-			"state":               s.State.Val,
-			"user-num_sta":        s.UserNumSta.Val,
-		})
+
+	if u.isOffline(s.State) {
+		batchStatePoint(r, tags, s.State, s.Uptime, s.LastSeen, "offline")
+		return
+	}
+
+	if u.isIdle(s.NumSta.Val, s.Uplink.TxBytesR.Val+s.Uplink.RxBytesR.Val) {
+		batchStatePoint(r, tags, s.State, s.Uptime, s.LastSeen, "idle")
+		return
+	}
+
+	fields := make(map[string]interface{}, deviceFields)
+	u.addUSWstat(fields, s.Stat.Sw)
+	u.addSysStats(fields, s.SysStats, s.SystemStats)
+	u.addDeviceState(fields, s.State, s.Cfgversion, s.KnownCfgversion, s.LedOverride)
+	fields["guest-num_sta"] = s.GuestNumSta.Val
+	fields["ip"] = s.IP
+	fields["bytes"] = s.Bytes.Val
+	fields["last_seen"] = s.LastSeen.Val
+	fields["rx_bytes"] = s.RxBytes.Val
+	fields["tx_bytes"] = s.TxBytes.Val
+	fields["uptime"] = s.Uptime.Val
+	fields["state"] = s.State.Val
+	fields["user-num_sta"] = s.UserNumSta.Val
+	fields["link_flaps"] = u.trackPortFlaps(s)
+
+	// Switches without sensors report zeros, which look like real readings.
+	if s.HasFan.Val {
+		fields["fan_level"] = s.FanLevel.Val
+	}
+
+	if s.HasTemperature.Val {
+		fields["general_temperature"] = s.GeneralTemperature.Val
+		fields["overheating"] = s.Overheating.Val
+	}
+
+	// Switches without PoE have no budget, and get no PoE fields.
+	if s.TotalMaxPower.Val > 0 {
+		used := poeUsed(s.PortTable)
+		fields["poe_budget"] = s.TotalMaxPower.Val
+		fields["poe_used"] = used
+		fields["poe_used_pct"] = used / s.TotalMaxPower.Val * 100 // nolint: gomnd
+	}
+
+	errs, packets := swHealth(s.Stat.Sw)
+	fields["health_score"] = u.healthScore(deviceHealth{
+		Uptime:  s.Uptime.Val,
+		CPU:     s.SystemStats.CPU.Val,
+		Mem:     s.SystemStats.Mem.Val,
+		Errors:  errs,
+		Packets: packets,
+		Temp:    s.GeneralTemperature.Val,
+	})
 
 	r.send(&metric{Table: "usw", Tags: tags, Fields: fields})
+	batchUplink(r, tags, s.LastUplink.UplinkMac, 0, s.Uplink.Speed, s.Uplink.Type)
 	u.batchPortTable(r, tags, s.PortTable)
 }
 
-func (u *InfluxUnifi) batchUSWstat(sw *unifi.Sw) map[string]interface{} {
+func (u *InfluxUnifi) addUSWstat(fields map[string]interface{}, sw *unifi.Sw) {
 	if sw == nil {
-		return map[string]interface{}{}
+		return
 	}
 
-	return map[string]interface{}{
-		"stat_bytes":      sw.Bytes.Val,
-		"stat_rx_bytes":   sw.RxBytes.Val,
-		"stat_rx_crypts":  sw.RxCrypts.Val,
-		"stat_rx_dropped": sw.RxDropped.Val,
-		"stat_rx_errors":  sw.RxErrors.Val,
-		"stat_rx_frags":   sw.RxFrags.Val,
-		"stat_rx_packets": sw.TxPackets.Val,
-		"stat_tx_bytes":   sw.TxBytes.Val,
-		"stat_tx_dropped": sw.TxDropped.Val,
-		"stat_tx_errors":  sw.TxErrors.Val,
-		"stat_tx_packets": sw.TxPackets.Val,
-		"stat_tx_retries": sw.TxRetries.Val,
+	fields["stat_bytes"] = sw.Bytes.Val
+	fields["stat_rx_bytes"] = sw.RxBytes.Val
+	fields["stat_rx_crypts"] = sw.RxCrypts.Val
+	fields["stat_rx_dropped"] = sw.RxDropped.Val
+	fields["stat_rx_errors"] = sw.RxErrors.Val
+	fields["stat_rx_frags"] = sw.RxFrags.Val
+	fields["stat_rx_packets"] = sw.TxPackets.Val
+	fields["stat_tx_bytes"] = sw.TxBytes.Val
+	fields["stat_tx_dropped"] = sw.TxDropped.Val
+	fields["stat_tx_errors"] = sw.TxErrors.Val
+	fields["stat_tx_packets"] = sw.TxPackets.Val
+	fields["stat_tx_retries"] = sw.TxRetries.Val
+}
+
+// poeUsed returns the total PoE power, in watts, drawn from a switch's ports.
+func poeUsed(pt []unifi.Port) float64 {
+	var used float64
+
+	for _, p := range pt {
+		if p.PoeEnable.Val && p.PortPoe.Val {
+			used += p.PoePower.Val
+		}
 	}
+
+	return used
 }
 
 func (u *InfluxUnifi) batchPortTable(r report, t map[string]string, pt []unifi.Port) {
@@ -104,6 +153,14 @@ func (u *InfluxUnifi) batchPortTable(r report, t map[string]string, pt []unifi.P
 			"tx_packets":   p.TxPackets.Val,
 		}
 
+		if flaps, ok := u.portFlaps(t["source"], t["mac"], p.PortIdx.Txt); ok {
+			fields["link_flaps"] = flaps
+		}
+
+		if p.Satisfaction.Txt != "" {
+			fields["satisfaction"] = p.Satisfaction.Val
+		}
+
 		if p.PoeEnable.Val && p.PortPoe.Val {
 			fields["poe_current"] = p.PoeCurrent.Val
 			fields["poe_power"] = p.PoePower.Val
@@ -0,0 +1,123 @@
+package influxunifi
+
+import (
+	"testing"
+
+	"github.com/unifi-poller/poller"
+	"github.com/unifi-poller/unifi"
+)
+
+func benchUSW() *unifi.USW {
+	s := &unifi.USW{SourceName: "https://unifi", SiteName: "default", Mac: "00:00:00:00:00:03", Name: "sw"}
+	s.Adopted.Val = true
+	s.State.Val = 1
+	s.NumSta.Val = 10
+	s.Stat.Sw = &unifi.Sw{}
+
+	return s
+}
+
+func BenchmarkBatchUSW(b *testing.B) {
+	u, _, _ := testUnifi(b, nil)
+	r := &sinkReport{m: &poller.Metrics{TS: testTime}}
+	s := benchUSW()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		u.batchUSW(r, s)
+	}
+}
+
+func TestBatchUSWFields(t *testing.T) {
+	u, w, _ := testUnifi(t, nil)
+	s := benchUSW()
+	s.Stat.Sw.RxBytes.Val = 100
+	s.SystemStats.CPU.Val = 12
+	s.LedOverride = "off"
+	s.Uptime.Val = 3600
+
+	mustReport(t, u, &poller.Metrics{TS: testTime, Devices: &unifi.Devices{USWs: []*unifi.USW{s}}})
+
+	points := w.points("usw")
+	if len(points) != 1 {
+		t.Fatalf("expected one usw point, got %d", len(points))
+	}
+
+	fields := pointFields(t, points[0])
+
+	// One field from each helper, and one set directly.
+	for field, want := range map[string]interface{}{
+		"stat_rx_bytes": 100.0,
+		"cpu":           12.0,
+		"led_override":  "off",
+		"uptime":        3600.0,
+	} {
+		if fields[field] != want {
+			t.Errorf("%s = %v (%T), want %v", field, fields[field], fields[field], want)
+		}
+	}
+}
+
+func TestIncludeOffline(t *testing.T) {
+	include, exclude := true, false
+
+	for name, test := range map[string]struct {
+		include *bool
+		full    bool
+	}{
+		"unset": {nil, true},
+		"true":  {&include, true},
+		"false": {&exclude, false},
+	} {
+		u, w, _ := testUnifi(t, &Config{IncludeOffline: test.include})
+		m := testMetrics(t)
+		m.Devices.USWs[0].State.Val = 0
+
+		r := mustReport(t, u, m)
+
+		if full := len(w.points("usw")) == 1; full != test.full {
+			t.Errorf("%s: usw point written = %v, want %v", name, full, test.full)
+		}
+
+		states := w.points("device_state")
+		if test.full && len(states) != 0 {
+			t.Errorf("%s: device_state written for an exported device", name)
+		}
+
+		if !test.full {
+			if len(states) != 1 || states[0].Tags()["reason"] != "offline" || r.Dropped["offline"] != 1 {
+				t.Fatalf("%s: want one offline device_state point, got %d, dropped: %v", name, len(states), r.Dropped)
+			}
+
+			if fields := pointFields(t, states[0]); fields["state"] != 0.0 {
+				t.Errorf("%s: device_state fields = %v, want state 0", name, fields)
+			}
+		}
+	}
+}
+
+func TestUSWPoE(t *testing.T) {
+	for name, budget := range map[string]float64{"non-PoE": 0, "PoE": 100} {
+		u, w, _ := testUnifi(t, nil)
+		m := testMetrics(t)
+		s := m.Devices.USWs[0]
+		s.TotalMaxPower.Val = budget
+		s.PortTable = []unifi.Port{{PortIdx: unifi.FlexInt{Val: 1, Txt: "1"}}}
+		s.PortTable[0].PoeEnable.Val, s.PortTable[0].PortPoe.Val, s.PortTable[0].PoePower.Val = true, true, 25
+
+		mustReport(t, u, m)
+
+		fields := pointFields(t, w.points("usw")[0])
+
+		for _, field := range []string{"poe_budget", "poe_used", "poe_used_pct"} {
+			if _, ok := fields[field]; ok != (budget > 0) {
+				t.Errorf("%s switch: %s field present = %v", name, field, ok)
+			}
+		}
+
+		if budget > 0 && fields["poe_used_pct"] != 25.0 {
+			t.Errorf("%s switch: poe_used_pct = %v, want 25", name, fields["poe_used_pct"])
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package influxunifi
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pingTimeout is how long the startup connectivity check waits for InfluxDB.
+const pingTimeout = 5 * time.Second
+
+// Validate checks a config for mistakes that would otherwise show up as write
+// errors later, and returns every problem found in one error. setup calls this
+// after defaults are applied, so unset values are not reported.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Protocol != protocolUDP {
+		problems = append(problems, checkURL("url", c.URL)...)
+	}
+
+	if c.Token != "" && (c.Org == "" || c.Bucket == "") {
+		problems = append(problems, "org and bucket must both be set when a token is provided")
+	}
+
+	if c.ClientSampleRate < 0 || c.ClientSampleRate > 1 {
+		problems = append(problems, "client_sample_rate must be between 0 and 1")
+	}
+
+	for name, val := range map[string]float64{
+		"write_retries":         float64(c.WriteRetries),
+		"max_writes_per_second": c.MaxWritesPerSecond,
+		"output_file_max_size":  float64(c.OutputFileMaxSize),
+		"payload_size":          float64(c.PayloadSize),
+		"idle_bytes_r":          float64(c.IdleBytesR),
+		"cardinality_warn":      float64(c.CardinalityWarn),
+		"series_budget":         float64(c.SeriesBudget),
+		"weak_signal_threshold": float64(c.WeakSignalThreshold),
+		"reset_threshold":       c.ResetThreshold,
+		"flush_interval":        float64(c.FlushInterval.Duration),
+		"interval_deadline":     float64(c.IntervalDeadline.Duration),
+		"stop_timeout":          float64(c.StopTimeout.Duration),
+		"buffer_size":           float64(c.BufferSize),
+	} {
+		if val < 0 {
+			problems = append(problems, name+" must not be negative")
+		}
+	}
+
+	for i, s := range c.Servers {
+		if s != nil && s.URL != "" && !strings.EqualFold(s.Protocol, protocolUDP) {
+			problems = append(problems, checkURL("servers["+strconv.Itoa(i)+"].url", s.URL)...)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+
+	return errors.Errorf("invalid InfluxDB config: %s", strings.Join(problems, "; "))
+}
+
+// checkURL returns a problem if an InfluxDB URL does not parse, or is not http(s).
+func checkURL(name, addr string) []string {
+	u, err := url.Parse(addr)
+
+	switch {
+	case err != nil:
+		return []string{name + " does not parse: " + err.Error()}
+	case u.Scheme != "http" && u.Scheme != "https":
+		return []string{name + " must start with http:// or https://"}
+	case u.Host == "":
+		return []string{name + " is missing a host"}
+	default:
+		return nil
+	}
+}
+
+// pingInflux logs an error if InfluxDB does not answer a ping. This does not stop
+// the poller, because InfluxDB may start later, but it explains missing data early.
+func (u *InfluxUnifi) pingInflux() {
+	if u.influx == nil || u.Protocol == protocolUDP {
+		return
+	}
+
+	if _, _, err := u.influx.Ping(pingTimeout); err != nil {
+		u.LogErrorf("InfluxDB is not reachable at %s (will keep trying): %v", u.URL, err)
+	}
+}
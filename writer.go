@@ -0,0 +1,462 @@
+package influxunifi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+)
+
+const userAgent = "InfluxDBClient"
+
+// writeClient wraps the InfluxDB v1 client and replaces its Write method.
+// The v1 client hides the HTTP response, and we need its status and headers.
+// Close is still provided by the embedded client, and so are Ping and Query
+// unless custom headers are configured: the v1 client cannot send them.
+// When a token is present, points are written with the InfluxDB 2.x API instead.
+type writeClient struct {
+	influx.Client
+	url     url.URL
+	headers map[string]string
+	user    string
+	pass    string
+	token   string
+	org     string
+	http    *http.Client
+	gzip    int32 // accessed atomically; 1 when compressing writes.
+	once    sync.Once
+	logf    func(msg string, v ...interface{})
+}
+
+// writeError is returned when InfluxDB responds to a write with a bad status code.
+type writeError struct {
+	Code       int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *writeError) Error() string {
+	return fmt.Sprintf("influxdb returned %d: %s", e.Code, e.Body)
+}
+
+// newClient returns the InfluxDB client for the configured protocol.
+// UDP writes are fire-and-forget: delivery is not confirmed, so points lost in transit
+// are not retried, and there is no authentication, TLS, compression or custom headers.
+// The database is set on the InfluxDB UDP listener, not per write. Batches are
+// split into packets of at most payload_size bytes.
+func (c *Config) newClient(logf func(msg string, v ...interface{})) (influx.Client, error) {
+	if c.Protocol != protocolUDP {
+		return c.newWriteClient(logf)
+	}
+
+	addr := c.URL
+	if u, err := url.Parse(c.URL); err == nil && u.Host != "" {
+		addr = u.Host // Accept udp://host:port as well as host:port.
+	}
+
+	return influx.NewUDPClient(influx.UDPConfig{Addr: addr, PayloadSize: c.PayloadSize})
+}
+
+// newWriteClient returns an influx client that writes points with our own HTTP client.
+// With a token configured, writes go to InfluxDB 2.x and the batch database is used as the bucket.
+func (c *Config) newWriteClient(logf func(msg string, v ...interface{})) (*writeClient, error) {
+	proxy, err := c.proxy()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	config := influx.HTTPConfig{
+		Addr:      c.URL,
+		Username:  c.User,
+		Password:  c.Pass,
+		TLSConfig: tlsConfig,
+		Proxy:     proxy,
+	}
+
+	client, err := influx.NewHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(config.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &writeClient{
+		Client:  client,
+		url:     *u,
+		headers: c.Headers,
+		user:    config.Username,
+		pass:    config.Password,
+		token:   c.Token,
+		org:     c.Org,
+		logf:    logf,
+		http: &http.Client{
+			Timeout: config.Timeout,
+			Transport: &headerTransport{
+				headers: c.Headers,
+				next:    &http.Transport{TLSClientConfig: config.TLSConfig, Proxy: config.Proxy},
+			},
+		},
+	}
+
+	if c.Compress {
+		w.gzip = 1
+	}
+
+	return w, nil
+}
+
+// headerTransport adds the configured custom headers to every request.
+// This allows writing through an auth gateway that wants its own header token.
+type headerTransport struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+// RoundTrip adds the headers to a copy of the request, and sends it.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) == 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// Ping checks that InfluxDB is up. With custom headers, the ping is sent with our
+// HTTP client, so an auth gateway in front of InfluxDB lets it through.
+func (c *writeClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	if len(c.headers) == 0 {
+		return c.Client.Ping(timeout)
+	}
+
+	params := url.Values{}
+	if timeout > 0 {
+		params.Set("wait_for_leader", fmt.Sprintf("%.0fs", timeout.Seconds()))
+	}
+
+	start := time.Now()
+
+	resp, body, err := c.do(http.MethodGet, "ping", params)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, "", errors.Errorf("influxdb ping returned %d: %s", resp.StatusCode, body)
+	}
+
+	return time.Since(start), resp.Header.Get("X-Influxdb-Version"), nil
+}
+
+// Query runs an InfluxQL query. With custom headers, the query is sent with our
+// HTTP client, so create_db and create_rp work through an auth gateway.
+// Chunked queries are not used here, and are left to the v1 client.
+func (c *writeClient) Query(q influx.Query) (*influx.Response, error) {
+	if len(c.headers) == 0 || q.Chunked {
+		return c.Client.Query(q)
+	}
+
+	queryParams, err := json.Marshal(q.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{"q": {q.Command}, "db": {q.Database}, "params": {string(queryParams)}}
+
+	if q.RetentionPolicy != "" {
+		params.Set("rp", q.RetentionPolicy)
+	}
+
+	if q.Precision != "" {
+		params.Set("epoch", q.Precision)
+	}
+
+	resp, body, err := c.do(http.MethodPost, "query", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response influx.Response
+
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+
+	if err := dec.Decode(&response); err != nil {
+		return nil, errors.Errorf("influxdb query returned %d: %s", resp.StatusCode, body)
+	}
+
+	if response.Err == "" && resp.StatusCode != http.StatusOK {
+		response.Err = fmt.Sprintf("influxdb query returned %d", resp.StatusCode)
+	}
+
+	return &response, nil
+}
+
+// do sends a v1 API request with our HTTP client, and returns the response and its body.
+func (c *writeClient) do(method, endpoint string, params url.Values) (*http.Response, string, error) {
+	u := c.url
+	u.Path = path.Join(u.Path, endpoint)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp, strings.TrimSpace(string(body)), nil
+}
+
+// contextWriter is an influx client that can abandon a write when a context is done.
+type contextWriter interface {
+	WriteContext(ctx context.Context, bp influx.BatchPoints) error
+}
+
+// Write sends a batch of points to InfluxDB, just like the v1 client does.
+func (c *writeClient) Write(bp influx.BatchPoints) error {
+	return c.WriteContext(context.Background(), bp)
+}
+
+// WriteContext sends a batch of points to InfluxDB using the provided context.
+// Bad responses return a *writeError that includes any Retry-After header value.
+func (c *writeClient) WriteContext(ctx context.Context, bp influx.BatchPoints) error {
+	body, err := c.encode(bp)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, bp, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnsupportedMediaType && atomic.CompareAndSwapInt32(&c.gzip, 1, 0) {
+		c.logf("InfluxDB does not accept gzip compressed writes, compression disabled")
+		return c.WriteContext(ctx, bp)
+	}
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return &writeError{
+			Code:       resp.StatusCode,
+			Body:       strings.TrimSpace(string(reply)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	return nil
+}
+
+// encode returns a batch of points as line protocol, gzip compressed if enabled.
+// The compression savings are logged for the first compressed batch.
+func (c *writeClient) encode(bp influx.BatchPoints) (*bytes.Buffer, error) {
+	b := lineProtocol(bp)
+
+	if atomic.LoadInt32(&c.gzip) == 0 {
+		return b, nil
+	}
+
+	var z bytes.Buffer
+
+	w := gzip.NewWriter(&z)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	c.once.Do(func() {
+		c.logf("InfluxDB write compression enabled: %d bytes compressed to %d bytes", b.Len(), z.Len())
+	})
+
+	return &z, nil
+}
+
+// lineProtocol returns a batch of points as line protocol, one point per line.
+func lineProtocol(bp influx.BatchPoints) *bytes.Buffer {
+	var b bytes.Buffer
+
+	for _, p := range bp.Points() {
+		if p == nil {
+			continue
+		}
+
+		b.WriteString(p.PrecisionString(v1Precision(bp.Precision())))
+		b.WriteByte('\n')
+	}
+
+	return &b
+}
+
+// newRequest returns a v1 or v2 write request for a batch of points.
+func (c *writeClient) newRequest(ctx context.Context, bp influx.BatchPoints, body io.Reader) (*http.Request, error) {
+	u := c.url
+	params := u.Query()
+
+	if c.token != "" {
+		u.Path = path.Join(u.Path, "api", "v2", "write")
+		params.Set("org", c.org)
+		params.Set("bucket", bp.Database())
+
+		if bp.Precision() != "" {
+			params.Set("precision", bp.Precision())
+		}
+	} else {
+		u.Path = path.Join(u.Path, "write")
+		params.Set("db", bp.Database())
+		params.Set("rp", bp.RetentionPolicy())
+		params.Set("precision", v1Precision(bp.Precision()))
+		params.Set("consistency", bp.WriteConsistency())
+	}
+
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "")
+	req.Header.Set("User-Agent", userAgent)
+
+	if atomic.LoadInt32(&c.gzip) == 1 {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	} else if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	return req, nil
+}
+
+// v1Precision converts a batch precision into the value the v1 API and the line
+// protocol encoder expect. They call microseconds "u", but a batch only accepts "us".
+func v1Precision(precision string) string {
+	if precision == "us" {
+		return "u"
+	}
+
+	return precision
+}
+
+// parseRetryAfter turns a Retry-After header value into a duration.
+// The header may contain a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value = strings.TrimSpace(value); value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+// proxy returns the proxy function for InfluxDB requests. Without a proxy_url,
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+func (c *Config) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if c.ProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing proxy_url")
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// tlsConfig returns the TLS settings used for the InfluxDB connection.
+// tls_cert and tls_key provide a client certificate for mutual TLS, and
+// tls_ca replaces the system roots used to verify the server when verify_ssl is on.
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: !c.VerifySSL} // nolint: gosec
+
+	if c.TLSCert != "" || c.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading tls_cert and tls_key")
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.TLSCA != "" {
+		ca, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading tls_ca")
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificates found in tls_ca: %s", c.TLSCA)
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,211 @@
+package influxunifi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// testCert is a certificate and key signed by a test CA, or self-signed when it is the CA.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+	pem  []byte
+	kpem []byte
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA if parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("encoding key: %v", err)
+	}
+
+	c := &testCert{key: key}
+	c.cert, _ = x509.ParseCertificate(der)
+	c.pem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	c.kpem = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+
+	if c.pair, err = tls.X509KeyPair(c.pem, c.kpem); err != nil {
+		t.Fatalf("loading key pair: %v", err)
+	}
+
+	return c
+}
+
+// writeFile writes data to a file in dir and returns its path.
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+
+	return file
+}
+
+func TestWriteClientMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxunifi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "influxdb", ca)
+	client := newTestCert(t, "poller", ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server.pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	// The server logs every rejected handshake; keep that out of the test output.
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.StartTLS()
+
+	defer s.Close()
+
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+	certFile := writeFile(t, dir, "client.pem", client.pem)
+	keyFile := writeFile(t, dir, "client.key", client.kpem)
+	badFile := writeFile(t, dir, "bad.pem", []byte("not a certificate"))
+
+	bp, _ := influx.NewBatchPoints(influx.BatchPointsConfig{Database: "unifi"})
+	p, _ := influx.NewPoint("test", nil, map[string]interface{}{"value": 1}, testTime)
+	bp.AddPoint(p)
+
+	tests := []struct {
+		name    string
+		config  Config
+		newErr  bool
+		writeOK bool
+	}{
+		{name: "pair and ca", config: Config{TLSCA: caFile, TLSCert: certFile, TLSKey: keyFile}, writeOK: true},
+		{name: "no client cert", config: Config{TLSCA: caFile}},
+		{name: "missing key", config: Config{TLSCA: caFile, TLSCert: certFile}, newErr: true},
+		{name: "bad key", config: Config{TLSCA: caFile, TLSCert: certFile, TLSKey: badFile}, newErr: true},
+		{name: "bad ca", config: Config{TLSCA: badFile, TLSCert: certFile, TLSKey: keyFile}, newErr: true},
+		{name: "missing ca", config: Config{TLSCA: filepath.Join(dir, "none.pem")}, newErr: true},
+	}
+
+	for _, test := range tests {
+		test.config.URL = s.URL
+		test.config.VerifySSL = true
+
+		w, err := test.config.newWriteClient(func(string, ...interface{}) {})
+		if (err != nil) != test.newErr {
+			t.Errorf("%s: newWriteClient error: %v", test.name, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if err := w.Write(bp); (err == nil) != test.writeOK {
+			t.Errorf("%s: write error: %v, want success = %v", test.name, err, test.writeOK)
+		}
+	}
+}
+
+func TestHeadersOnPingAndQuery(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Gateway-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/ping":
+			w.Header().Set("X-Influxdb-Version", "1.8.0")
+			w.WriteHeader(http.StatusNoContent)
+		case "/query":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"results":[{"series":[{"name":"databases","values":[["unifi"]]}]}]}`))
+		}
+	}))
+	defer s.Close()
+
+	c := &Config{URL: s.URL, Headers: map[string]string{"X-Gateway-Token": "secret"}}
+
+	w, err := c.newWriteClient(func(string, ...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, version, err := w.Ping(time.Second); err != nil || version != "1.8.0" {
+		t.Errorf("Ping = %q, %v, want the version and no error", version, err)
+	}
+
+	u, _, _ := testUnifi(t, c)
+	u.influx = w
+
+	names, err := u.queryNames("SHOW DATABASES", "")
+	if err != nil || !names["unifi"] {
+		t.Errorf("queryNames = %v, %v, want unifi", names, err)
+	}
+
+	// Without the header the gateway rejects the ping.
+	c.Headers = nil
+
+	if w, err = c.newWriteClient(func(string, ...interface{}) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := w.Ping(time.Second); err == nil {
+		t.Error("Ping without the header succeeded")
+	}
+}